@@ -0,0 +1,542 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/vasilisp/lingograph"
+	"github.com/vasilisp/lingograph/internal/util"
+	"github.com/vasilisp/lingograph/pkg/slicev"
+	"github.com/vasilisp/lingograph/store"
+)
+
+// Model is the identifier of a Claude model. Identifiers that are not among
+// the constants can be used directly, e.g., Model("claude-3-7-sonnet-latest").
+type Model string
+
+const (
+	ClaudeOpus41   Model = "claude-opus-4-1"
+	ClaudeSonnet45 Model = "claude-sonnet-4-5"
+	ClaudeSonnet4  Model = "claude-sonnet-4-0"
+	ClaudeHaiku35  Model = "claude-3-5-haiku-latest"
+)
+
+const (
+	defaultBaseURL = "https://api.anthropic.com"
+	apiVersion     = "2023-06-01"
+	// defaultMaxTokens is the cap on the length of responses, which the API
+	// requires (see Actor.SetMaxTokens)
+	defaultMaxTokens = 4096
+)
+
+func init() {
+	lingograph.RegisterMetadata[[]toolUse]("anthropic.tool_uses")
+	lingograph.RegisterMetadata[toolResult]("anthropic.tool_result")
+}
+
+// toolUse is a tool call of an assistant message.
+type toolUse struct {
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+// toolResult is the ModelMetadata of function messages. Multiple function
+// messages for the same tool call are sent as a single tool result.
+type toolResult struct {
+	ID      string `json:"id"`
+	IsError bool   `json:"is_error,omitempty"`
+}
+
+type function struct {
+	tool lingograph.Tool
+}
+
+// request holds the parameters of a message request that do not depend on
+// the chat history.
+type request struct {
+	model        Model
+	systemPrompt string
+	temperature  *float64
+	maxTokens    int
+	functions    map[string]function
+}
+
+// Client defines the interface for interacting with the Anthropic API.
+type Client interface {
+	ask(ctx context.Context, req request, history slicev.RO[lingograph.Message], r store.Store, emit func(lingograph.Event)) ([]lingograph.Message, error)
+}
+
+type client struct {
+	apiKey  string
+	baseURL string
+	http    *http.Client
+}
+
+// APIKeyFromEnv retrieves the Anthropic API key from the ANTHROPIC_API_KEY
+// environment variable. It will exit if the environment variable is not set.
+func APIKeyFromEnv() string {
+	key, exists := os.LookupEnv("ANTHROPIC_API_KEY")
+	if !exists {
+		log.Fatal("ANTHROPIC_API_KEY environment variable is not set")
+	}
+	return key
+}
+
+// NewClient creates a new Anthropic client with the provided API key.
+// It will exit if the API key is empty.
+func NewClient(apiKey string) Client {
+	if apiKey == "" {
+		log.Fatal("apiKey is empty")
+	}
+
+	return &client{apiKey: apiKey, baseURL: defaultBaseURL, http: http.DefaultClient}
+}
+
+// IsRetryable is the default RetryPredicate of Anthropic Actors. API errors
+// are retried if they are retryable (see APIError.Retryable). Errors without
+// an API response, e.g., network errors and function errors, are retried.
+func IsRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	return true
+}
+
+// toBlocks converts the content of a message to content blocks.
+func toBlocks(msg lingograph.Message) ([]apiBlock, error) {
+	if len(msg.Parts) == 0 {
+		if msg.Content == "" {
+			return nil, nil
+		}
+		return []apiBlock{{Type: "text", Text: msg.Content}}, nil
+	}
+
+	blocks := make([]apiBlock, 0, len(msg.Parts))
+
+	for _, part := range msg.Parts {
+		switch part.Kind {
+		case lingograph.PartText:
+			if part.Text != "" {
+				blocks = append(blocks, apiBlock{Type: "text", Text: part.Text})
+			}
+		case lingograph.PartImage:
+			source, err := imageSource(part.URL)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, apiBlock{Type: "image", Source: source})
+		default:
+			return nil, fmt.Errorf("unsupported content part kind %d", part.Kind)
+		}
+	}
+
+	return blocks, nil
+}
+
+// imageSource converts the URL of an image part, which may be a data URL, to
+// an image source.
+func imageSource(url string) (*apiSource, error) {
+	data, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return &apiSource{Type: "url", URL: url}, nil
+	}
+
+	mediaType, encoded, ok := strings.Cut(data, ";base64,")
+	if !ok {
+		return nil, fmt.Errorf("unsupported data URL")
+	}
+
+	return &apiSource{Type: "base64", MediaType: mediaType, Data: encoded}, nil
+}
+
+// appendBlocks appends blocks to messages as a message of the given role,
+// merging them into the last message if it has the same role, since the API
+// expects alternating roles.
+func appendBlocks(messages []apiMessage, role string, blocks ...apiBlock) []apiMessage {
+	if len(blocks) == 0 {
+		return messages
+	}
+
+	if n := len(messages); n > 0 && messages[n-1].Role == role {
+		messages[n-1].Content = append(messages[n-1].Content, blocks...)
+		return messages
+	}
+
+	return append(messages, apiMessage{Role: role, Content: blocks})
+}
+
+// toMessages converts the chat history to Messages API messages. Function
+// messages become tool results of user messages; Developer messages are sent
+// as user messages, since the system prompt is separate. System messages are
+// left out (see systemPrompt), and so are the tool uses without results, e.g.,
+// in a history filtered with lingograph.WithFilter, since the API rejects
+// them.
+func toMessages(history slicev.RO[lingograph.Message]) ([]apiMessage, error) {
+	messages := make([]apiMessage, 0, history.Len())
+
+	answered := make(map[string]bool)
+	for index := range history.Len() {
+		if result, ok := history.At(index).ModelMetadata.(toolResult); ok {
+			answered[result.ID] = true
+		}
+	}
+
+	for index := range history.Len() {
+		msg := history.At(index)
+
+		switch msg.Role {
+		case lingograph.System:
+			continue
+		case lingograph.Assistant:
+			blocks, err := toBlocks(msg)
+			if err != nil {
+				return nil, err
+			}
+
+			uses, _ := msg.ModelMetadata.([]toolUse)
+			for _, use := range uses {
+				if !answered[use.ID] {
+					continue
+				}
+				blocks = append(blocks, apiBlock{Type: "tool_use", ID: use.ID, Name: use.Name, Input: use.Input})
+			}
+
+			messages = appendBlocks(messages, "assistant", blocks...)
+		case lingograph.Function:
+			result, ok := msg.ModelMetadata.(toolResult)
+			if !ok {
+				return nil, fmt.Errorf("function message without tool use ID")
+			}
+
+			// empty text blocks are not allowed
+			content, err := toBlocks(msg)
+			if err != nil {
+				return nil, err
+			}
+
+			// merge the results of the same tool call
+			if n := len(messages); n > 0 && messages[n-1].Role == "user" {
+				last := messages[n-1].Content
+				if m := len(last); m > 0 && last[m-1].Type == "tool_result" && last[m-1].ToolUseID == result.ID {
+					last[m-1].Content = append(last[m-1].Content, content...)
+					last[m-1].IsError = last[m-1].IsError || result.IsError
+					continue
+				}
+			}
+
+			messages = appendBlocks(messages, "user", apiBlock{
+				Type:      "tool_result",
+				ToolUseID: result.ID,
+				Content:   content,
+				IsError:   result.IsError,
+			})
+		default:
+			blocks, err := toBlocks(msg)
+			if err != nil {
+				return nil, err
+			}
+
+			messages = appendBlocks(messages, "user", blocks...)
+		}
+	}
+
+	return messages, nil
+}
+
+// call executes a tool use, and returns the function messages and the other
+// messages (e.g., images) of the results. Errors of the function are handled
+// according to their disposition (see lingograph.ToolError).
+func (req *request) call(ctx context.Context, use toolUse, r store.Store, emit func(lingograph.Event)) ([]lingograph.Message, []lingograph.Message, error) {
+	fn, ok := req.functions[use.Name]
+	if !ok {
+		return nil, nil, fmt.Errorf("function %s not found", use.Name)
+	}
+
+	emit(lingograph.Event{Kind: lingograph.EventToolStart, Tool: use.Name})
+
+	messages, err := lingograph.CallTool(ctx, fn.tool.Handler, string(use.Input), r)
+
+	emit(lingograph.Event{Kind: lingograph.EventToolEnd, Tool: use.Name, Err: err})
+
+	if err != nil {
+		if lingograph.DispositionOf(err) != lingograph.ToolRecoverable {
+			return nil, nil, fmt.Errorf("error calling function %s: %w", use.Name, err)
+		}
+
+		message := lingograph.Message{
+			Role:          lingograph.Function,
+			Content:       "Error: " + err.Error(),
+			ModelMetadata: toolResult{ID: use.ID, IsError: true},
+		}
+		return []lingograph.Message{message}, nil, nil
+	}
+
+	functionMessages := make([]lingograph.Message, 0, len(messages))
+	otherMessages := make([]lingograph.Message, 0)
+
+	for _, msg := range messages {
+		if msg.Role != lingograph.Function {
+			otherMessages = append(otherMessages, msg)
+			continue
+		}
+
+		msg.Content = fn.tool.Format.Fence(msg.Content)
+		msg.ModelMetadata = toolResult{ID: use.ID}
+		functionMessages = append(functionMessages, msg)
+	}
+
+	// every tool use needs a result
+	if len(functionMessages) == 0 {
+		functionMessages = append(functionMessages, lingograph.Message{
+			Role:          lingograph.Function,
+			Content:       "",
+			ModelMetadata: toolResult{ID: use.ID},
+		})
+	}
+
+	return functionMessages, otherMessages, nil
+}
+
+func (client *client) post(ctx context.Context, body apiRequest) (*apiResponse, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, client.baseURL+"/v1/messages", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("content-type", "application/json")
+	httpReq.Header.Set("x-api-key", client.apiKey)
+	httpReq.Header.Set("anthropic-version", apiVersion)
+
+	httpResp, err := client.http.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		apiErr := &APIError{StatusCode: httpResp.StatusCode, header: httpResp.Header}
+
+		var errResp apiErrorResponse
+		if json.Unmarshal(data, &errResp) == nil {
+			apiErr.Type = errResp.Error.Type
+			apiErr.Message = errResp.Error.Message
+		} else {
+			apiErr.Message = string(data)
+		}
+
+		return nil, apiErr
+	}
+
+	var response apiResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("cannot parse response: %w", err)
+	}
+
+	return &response, nil
+}
+
+// systemPrompt returns the system prompt of the actor followed by the System
+// messages of the history, since the Messages API takes a single system
+// prompt.
+func systemPrompt(prompt string, history slicev.RO[lingograph.Message]) string {
+	parts := make([]string, 0, 1)
+	if prompt != "" {
+		parts = append(parts, prompt)
+	}
+
+	for i := range history.Len() {
+		if msg := history.At(i); msg.Role == lingograph.System {
+			parts = append(parts, msg.Content)
+		}
+	}
+
+	return strings.Join(parts, "\n\n")
+}
+
+func (client *client) ask(ctx context.Context, req request, history slicev.RO[lingograph.Message], r store.Store, emit func(lingograph.Event)) ([]lingograph.Message, error) {
+	messages, err := toMessages(history)
+	if err != nil {
+		return nil, err
+	}
+
+	body := apiRequest{
+		Model:       string(req.model),
+		MaxTokens:   req.maxTokens,
+		System:      systemPrompt(req.systemPrompt, history),
+		Messages:    messages,
+		Temperature: req.temperature,
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(req.functions)) {
+		fn := req.functions[name]
+		body.Tools = append(body.Tools, apiTool{
+			Name:        fn.tool.Name,
+			Description: fn.tool.Description,
+			InputSchema: fn.tool.Schema,
+		})
+	}
+
+	response, err := client.post(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var content strings.Builder
+	var uses []toolUse
+
+	for _, block := range response.Content {
+		switch block.Type {
+		case "text":
+			content.WriteString(block.Text)
+		case "tool_use":
+			input := block.Input
+			if len(input) == 0 {
+				input = json.RawMessage("{}")
+			}
+			uses = append(uses, toolUse{ID: block.ID, Name: block.Name, Input: input})
+		}
+	}
+
+	assistant := lingograph.Message{Role: lingograph.Assistant, Content: content.String()}
+	if len(uses) > 0 {
+		assistant.ModelMetadata = uses
+	}
+
+	functionMessages := make([]lingograph.Message, 0)
+	// non-function messages (e.g., images) have to follow all the function
+	// messages, since the tool results have to come first
+	trailingMessages := make([]lingograph.Message, 0)
+
+	for _, use := range uses {
+		results, others, err := req.call(ctx, use, r, emit)
+		if err != nil {
+			return nil, err
+		}
+
+		functionMessages = append(functionMessages, results...)
+		trailingMessages = append(trailingMessages, others...)
+	}
+
+	result := make([]lingograph.Message, 0, 1+len(functionMessages)+len(trailingMessages))
+	result = append(result, assistant)
+	result = append(result, functionMessages...)
+	result = append(result, trailingMessages...)
+
+	return result, nil
+}
+
+// FunctionSet is a set of functions that the model can call. It is
+// implemented by Actor.
+type FunctionSet interface {
+	addFunction(fn function)
+}
+
+// Actor is an Anthropic-specific Actor implementation.
+type Actor interface {
+	FunctionSet
+	lingograph.Actor
+	// SetMaxTokens sets the cap on the length of responses, which defaults to
+	// 4096 tokens.
+	SetMaxTokens(maxTokens int)
+}
+
+type actor struct {
+	lingograph.Actor
+	client    Client
+	request   request
+	mu        sync.RWMutex
+	functions map[string]function
+}
+
+// NewActor creates a new Actor instance with the specified client, model,
+// system prompt, and optional temperature setting. It mirrors openai.NewActor,
+// so pipelines can switch providers by changing the constructor.
+func NewActor(client Client, model Model, systemPrompt string, temperature *float64) Actor {
+	if model == "" {
+		log.Fatal("cannot create actor: empty model")
+	}
+
+	a := &actor{
+		client: client,
+		request: request{
+			model:        model,
+			systemPrompt: systemPrompt,
+			temperature:  temperature,
+			maxTokens:    defaultMaxTokens,
+		},
+		functions: make(map[string]function),
+	}
+
+	a.Actor = lingograph.WithRetryPredicate(lingograph.NewActorContext(lingograph.Assistant, a.fn), IsRetryable)
+
+	return a
+}
+
+func (a *actor) fn(ctx context.Context, history slicev.RO[lingograph.Message], r store.Store, emit func(lingograph.Event)) ([]lingograph.Message, error) {
+	req := a.request
+
+	a.mu.RLock()
+	req.functions = make(map[string]function, len(a.functions))
+	for name, fn := range a.functions {
+		req.functions[name] = fn
+	}
+	a.mu.RUnlock()
+
+	return a.client.ask(ctx, req, history, r, emit)
+}
+
+// Unwrap returns the underlying lingograph.Actor, whose identity the Actor
+// shares.
+func (a *actor) Unwrap() lingograph.Actor {
+	return a.Actor
+}
+
+func (a *actor) SetMaxTokens(maxTokens int) {
+	util.Assert(maxTokens > 0, "SetMaxTokens non-positive maxTokens")
+
+	a.request.maxTokens = maxTokens
+}
+
+func (a *actor) addFunction(fn function) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.functions[fn.tool.Name] = fn
+}
+
+// AddTool adds a provider-neutral Tool to the Actor, so that the model can
+// call it.
+func AddTool(a FunctionSet, tool lingograph.Tool) {
+	a.addFunction(function{tool: tool})
+}
+
+// AddFunction adds a function to the Actor that can be called by the model.
+// The function takes an input type I and returns an output type O, as with
+// openai.AddFunction. The output is marshaled to JSON, unless it is a
+// lingograph.ImageResult or a lingograph.DisplayResult.
+func AddFunction[I any, O any](a FunctionSet, name string, description string, fn func(I, store.Store) (O, error), options ...lingograph.DecodeOption) {
+	AddTool(a, lingograph.NewTool(name, description, fn, options...))
+}
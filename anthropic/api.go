@@ -0,0 +1,120 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wire types of the Messages API
+
+type apiSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type apiBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   []apiBlock      `json:"content,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+	Source    *apiSource      `json:"source,omitempty"`
+}
+
+type apiMessage struct {
+	Role    string     `json:"role"`
+	Content []apiBlock `json:"content"`
+}
+
+type apiTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+type apiRequest struct {
+	Model       string       `json:"model"`
+	MaxTokens   int          `json:"max_tokens"`
+	System      string       `json:"system,omitempty"`
+	Messages    []apiMessage `json:"messages"`
+	Tools       []apiTool    `json:"tools,omitempty"`
+	Temperature *float64     `json:"temperature,omitempty"`
+}
+
+type apiResponse struct {
+	Model      string     `json:"model"`
+	Content    []apiBlock `json:"content"`
+	StopReason string     `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int64 `json:"input_tokens"`
+		OutputTokens int64 `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+type apiErrorResponse struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// APIError is an error response of the Anthropic API.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response, e.g., 429.
+	StatusCode int
+	// Type is the type of the error reported by the API, e.g.,
+	// "overloaded_error".
+	Type string
+	// Message is the description of the error reported by the API.
+	Message string
+	header  http.Header
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("anthropic: %d %s: %s", e.StatusCode, e.Type, e.Message)
+}
+
+// Retryable reports whether the request may succeed if retried: on rate
+// limiting (429), on server errors (500-504), and when the API is overloaded
+// (529).
+func (e *APIError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusNotImplemented,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+		529:
+		return true
+	}
+	return false
+}
+
+// RetryAfter returns the delay before retrying that the API suggests with the
+// Retry-After header, or 0 if there is none (see lingograph.RetryAfterError).
+func (e *APIError) RetryAfter() time.Duration {
+	value := strings.TrimSpace(e.header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return max(0, time.Duration(seconds*float64(time.Second)))
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(date))
+	}
+
+	return 0
+}
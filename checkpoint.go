@@ -0,0 +1,216 @@
+package lingograph
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/vasilisp/lingograph/internal/util"
+	"github.com/vasilisp/lingograph/store"
+)
+
+const checkpointVersion = 1
+
+type metadataType struct {
+	name   string
+	decode func(json.RawMessage) (any, error)
+}
+
+var (
+	metadataMu sync.RWMutex
+	// metadataByType and metadataByName hold the ModelMetadata types that can
+	// be checkpointed
+	metadataByType = make(map[reflect.Type]metadataType)
+	metadataByName = make(map[string]metadataType)
+)
+
+// RegisterMetadata registers the ModelMetadata type T under the given name,
+// so that messages carrying it can be checkpointed. T has to be
+// JSON-serializable. Providers register their metadata types during
+// initialization.
+func RegisterMetadata[T any](name string) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	_, exists := metadataByName[name]
+	util.Assert(!exists, "RegisterMetadata duplicate name "+name)
+
+	t := metadataType{
+		name: name,
+		decode: func(raw json.RawMessage) (any, error) {
+			var val T
+			if err := json.Unmarshal(raw, &val); err != nil {
+				return nil, err
+			}
+			return val, nil
+		},
+	}
+
+	metadataByType[reflect.TypeFor[T]()] = t
+	metadataByName[name] = t
+}
+
+type checkpointPart struct {
+	Kind   PartKind `json:"kind"`
+	Text   string   `json:"text,omitempty"`
+	URL    string   `json:"url,omitempty"`
+	Data   []byte   `json:"data,omitempty"`
+	Format string   `json:"format,omitempty"`
+}
+
+type checkpointMetadata struct {
+	Kind  string          `json:"kind"`
+	Value json.RawMessage `json:"value"`
+}
+
+type checkpointMessage struct {
+	Role     Role                `json:"role"`
+	Content  string              `json:"content"`
+	Parts    []checkpointPart    `json:"parts,omitempty"`
+	Pinned   bool                `json:"pinned,omitempty"`
+	Display  string              `json:"display,omitempty"`
+	Time     time.Time           `json:"time,omitzero"`
+	Metadata *checkpointMetadata `json:"metadata,omitempty"`
+}
+
+type checkpoint struct {
+	Version  int                        `json:"version"`
+	Messages []checkpointMessage        `json:"messages"`
+	Store    map[string]json.RawMessage `json:"store"`
+	Meta     map[string]string          `json:"meta,omitempty"`
+}
+
+// Checkpoint writes the state of the chat to w as JSON: the history, including
+// the model metadata of the messages (e.g., pending tool calls), the
+// persistent vars of the store (see store.PersistentVar) and the metadata of
+// the chat. Step counters and other loop state have to be kept in persistent
+// vars to be checkpointed. Checkpoint fails if a message carries model
+// metadata of a type that has not been registered (see RegisterMetadata).
+func Checkpoint(w io.Writer, chat Chat) error {
+	history := chat.History()
+
+	cp := checkpoint{
+		Version:  checkpointVersion,
+		Messages: make([]checkpointMessage, 0, history.Len()),
+		Meta:     chat.Meta(),
+	}
+
+	metadataMu.RLock()
+	defer metadataMu.RUnlock()
+
+	for i := range history.Len() {
+		msg := history.At(i)
+
+		message := checkpointMessage{Role: msg.Role, Content: msg.Content, Pinned: msg.Pinned, Display: msg.Display, Time: msg.Time}
+
+		for _, part := range msg.Parts {
+			message.Parts = append(message.Parts, checkpointPart(part))
+		}
+
+		if msg.ModelMetadata != nil {
+			t, ok := metadataByType[reflect.TypeOf(msg.ModelMetadata)]
+			if !ok {
+				return fmt.Errorf("message %d: unregistered metadata type %T", i, msg.ModelMetadata)
+			}
+
+			raw, err := json.Marshal(msg.ModelMetadata)
+			if err != nil {
+				return fmt.Errorf("message %d: %w", i, err)
+			}
+
+			message.Metadata = &checkpointMetadata{Kind: t.name, Value: raw}
+		}
+
+		cp.Messages = append(cp.Messages, message)
+	}
+
+	values, err := store.Snapshot(chat.store())
+	if err != nil {
+		return err
+	}
+	cp.Store = values
+
+	return json.NewEncoder(w).Encode(cp)
+}
+
+// Resume reads a checkpoint written by Checkpoint, and returns a Chat with the
+// same history, store and metadata, so that a pipeline, e.g., an agentic loop,
+// can continue where it stopped. The messages of the resumed Chat are not
+// attributed to any Actor (see Message.IsFrom). The options configure the
+// Chat as with NewChat, e.g., its TrimStrategy, which is not part of the
+// checkpoint.
+func Resume(r io.Reader, options ...ChatOption) (Chat, error) {
+	var cp checkpoint
+	if err := json.NewDecoder(r).Decode(&cp); err != nil {
+		return nil, err
+	}
+
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d", cp.Version)
+	}
+
+	metadataMu.RLock()
+	defer metadataMu.RUnlock()
+
+	history := make([]Message, 0, len(cp.Messages))
+
+	for i, message := range cp.Messages {
+		msg := Message{Role: message.Role, Content: message.Content, Pinned: message.Pinned, Display: message.Display, Time: message.Time}
+
+		for _, part := range message.Parts {
+			msg.Parts = append(msg.Parts, ContentPart(part))
+		}
+
+		if message.Metadata != nil {
+			t, ok := metadataByName[message.Metadata.Kind]
+			if !ok {
+				return nil, fmt.Errorf("message %d: unknown metadata kind %s", i, message.Metadata.Kind)
+			}
+
+			metadata, err := t.decode(message.Metadata.Value)
+			if err != nil {
+				return nil, fmt.Errorf("message %d: %w", i, err)
+			}
+			msg.ModelMetadata = metadata
+		}
+
+		history = append(history, msg)
+	}
+
+	restored, err := store.Restore(cp.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := newMetadata()
+	for key, value := range cp.Meta {
+		meta.set(key, value)
+	}
+
+	c := &chat{history: history, storeImpl: restored, offsetUnique: 0, meta: meta}
+	for _, option := range options {
+		option(c)
+	}
+
+	return c, nil
+}
+
+// MarshalChat returns the checkpoint of the chat (see Checkpoint), e.g., for
+// storing a conversation in a database between requests.
+func MarshalChat(chat Chat) ([]byte, error) {
+	var b bytes.Buffer
+	if err := Checkpoint(&b, chat); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalChat returns the Chat of a checkpoint returned by MarshalChat (see
+// Resume).
+func UnmarshalChat(data []byte, options ...ChatOption) (Chat, error) {
+	return Resume(bytes.NewReader(data), options...)
+}
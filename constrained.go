@@ -0,0 +1,61 @@
+package lingograph
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/vasilisp/lingograph/internal/util"
+	"github.com/vasilisp/lingograph/pkg/slicev"
+	"github.com/vasilisp/lingograph/store"
+)
+
+// constrainedCorrections is the number of times Constrained asks the actor to
+// correct an output that does not match
+const constrainedCorrections = 2
+
+// Constrained creates an Actor that produces the messages of actor, provided
+// that the content of the last one fully matches pattern, e.g., a single
+// number or yes/no. Otherwise, the mismatching output and a correction are
+// fed back to actor, which is asked again, up to 2 times. If no output
+// matches, the attempt fails with an error, and the Pipeline of the returned
+// Actor retries within its retry limit. The corrections are not written to
+// the chat.
+func Constrained(actor Actor, pattern *regexp.Regexp) Actor {
+	util.Assert(pattern != nil, "Constrained nil pattern")
+
+	anchored := regexp.MustCompile(`^(?:` + pattern.String() + `)$`)
+	pipeline := actor.Pipeline(nil, false, 1)
+
+	fn := func(ctx context.Context, history slicev.RO[Message], r store.Store, emit func(Event)) ([]Message, error) {
+		messages := make([]Message, history.Len())
+		history.CopyTo(messages)
+
+		var content string
+
+		for i := 0; i <= constrainedCorrections; i++ {
+			scratch := &chat{history: messages, storeImpl: r, onEvent: forwardEvents(emit), meta: newMetadata(), ctx: ctx, unbounded: true}
+
+			written, err := ExecuteMessages(pipeline, scratch)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(written) == 0 {
+				return nil, fmt.Errorf("constrained actor wrote no messages")
+			}
+
+			content = written[len(written)-1].Content
+			if anchored.MatchString(content) {
+				return written, nil
+			}
+
+			correction := fmt.Sprintf("Your answer %q does not match the required format %s. Answer again, in that format only.", content, pattern)
+			messages = append(scratch.history, Message{Role: User, Content: correction})
+		}
+
+		return nil, fmt.Errorf("output %q does not match %s after %d corrections", content, pattern, constrainedCorrections)
+	}
+
+	return NewActorVariant(actor, Assistant, fn)
+}
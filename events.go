@@ -0,0 +1,197 @@
+package lingograph
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/vasilisp/lingograph/internal/util"
+	"github.com/vasilisp/lingograph/store"
+)
+
+// EventKind is the kind of an Event.
+type EventKind uint8
+
+const (
+	// EventWrite is a message being written to the history.
+	EventWrite EventKind = iota
+	// EventTrim is the history being cleared.
+	EventTrim
+	// EventSet is a store variable being set.
+	EventSet
+	// EventActor is an invocation of an actor, including failed attempts. If
+	// Err is not nil and Attempt is less than RetryLimit, the actor will be
+	// retried.
+	EventActor
+	// EventToolStart is an actor starting to execute a tool call.
+	EventToolStart
+	// EventToolEnd is an actor finishing a tool call. Err holds the error of
+	// the tool, if any.
+	EventToolEnd
+	// EventEdit is a message of the history being replaced, e.g., the
+	// scratchpad. Message holds the new message.
+	EventEdit
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventWrite:
+		return "write"
+	case EventTrim:
+		return "trim"
+	case EventSet:
+		return "set"
+	case EventActor:
+		return "actor"
+	case EventToolStart:
+		return "tool start"
+	case EventToolEnd:
+		return "tool end"
+	case EventEdit:
+		return "edit"
+	}
+	return "unknown"
+}
+
+// Event describes a single operation on a Chat.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+	// Message is the message written, for EventWrite.
+	Message Message
+	// VarID is the ID of the variable set (see store.Var.ID), for EventSet.
+	VarID int64
+	// Value is the new value of the variable, for EventSet.
+	Value any
+	// Tool is the name of the tool, for EventToolStart and EventToolEnd.
+	Tool string
+	// Err is the error returned by the actor, for EventActor, or by the tool,
+	// for EventToolEnd.
+	Err error
+	// Attempt is the number of the attempt, starting from 1, for EventActor.
+	Attempt int
+	// RetryLimit is the maximum number of attempts, for EventActor.
+	RetryLimit int
+	actor      actorID
+}
+
+// IsFrom reports whether the event was caused by the given actor. Only
+// EventActor, EventToolStart, and EventToolEnd events carry actor
+// information.
+func (e Event) IsFrom(a Actor) bool {
+	id, ok := identityOf(a)
+	return ok && e.actor == id
+}
+
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) add(event Event) {
+	event.Time = time.Now()
+
+	r.mu.Lock()
+	r.events = append(r.events, event)
+	r.mu.Unlock()
+}
+
+// RecordingChat is a Chat that records an ordered log of all the operations
+// performed on it: messages written, trims, store variables set, and actor
+// invocations. Unlike the history, the log is never trimmed.
+type RecordingChat interface {
+	Chat
+	// Events returns a copy of the events recorded so far.
+	Events() []Event
+}
+
+type recordingChat struct {
+	chat
+	recorder *recorder
+}
+
+// NewRecordingChat creates and returns a new RecordingChat instance with an
+// empty history and a fresh store.
+func NewRecordingChat(options ...ChatOption) RecordingChat {
+	rec := &recorder{}
+
+	hook := func(id int64, val any) {
+		rec.add(Event{Kind: EventSet, VarID: id, Value: val})
+	}
+
+	c := &recordingChat{
+		chat: chat{
+			history:   make([]Message, 0),
+			storeImpl: store.NewStoreWithHook(hook),
+			onEvent:   rec.add,
+			meta:      newMetadata(),
+		},
+		recorder: rec,
+	}
+	for _, option := range options {
+		option(&c.chat)
+	}
+	return c
+}
+
+func (c *recordingChat) Events() []Event {
+	c.recorder.mu.Lock()
+	defer c.recorder.mu.Unlock()
+
+	return slices.Clone(c.recorder.events)
+}
+
+type observedChat struct {
+	Chat
+	observer func(Event)
+}
+
+func (c *observedChat) write(message Message) {
+	c.observer(Event{Kind: EventWrite, Time: time.Now(), Message: message})
+	c.Chat.write(message)
+}
+
+func (c *observedChat) edit(find func(Message) bool, message Message) bool {
+	if !c.Chat.edit(find, message) {
+		return false
+	}
+
+	c.observer(Event{Kind: EventEdit, Time: time.Now(), Message: message})
+	return true
+}
+
+func (c *observedChat) trim() {
+	c.observer(Event{Kind: EventTrim, Time: time.Now()})
+	c.Chat.trim()
+}
+
+func (c *observedChat) record(event Event) {
+	event.Time = time.Now()
+	c.observer(event)
+	c.Chat.record(event)
+}
+
+type observed struct {
+	pipeline Pipeline
+	observer func(Event)
+}
+
+// Observe creates a Pipeline that executes the given pipeline, passing the
+// messages written, the trims, and the actor invocations to observer as they
+// happen, as well as the events reported by actors (e.g., tool calls). For
+// example, failed EventActor events can be used to show retry progress to the
+// user. The observer is called synchronously, and possibly
+// concurrently within Parallel.
+func Observe(pipeline Pipeline, observer func(Event)) Pipeline {
+	util.Assert(observer != nil, "Observe nil observer")
+
+	return &observed{pipeline: pipeline, observer: observer}
+}
+
+func (o *observed) Execute(chat Chat) error {
+	return o.pipeline.Execute(&observedChat{Chat: chat, observer: o.observer})
+}
+
+func (o *observed) trims() bool {
+	return o.pipeline.trims()
+}
@@ -21,7 +21,7 @@ func main() {
 			return true
 		},
 		lingograph.Chain(
-			extra.Stdin().Pipeline(nil, false, 0),
+			extra.Readline("user: ").Pipeline(nil, false, 0),
 			openAIActor.Pipeline(extra.Echoln(os.Stdout, "assistant: "), false, 1),
 		),
 	)
@@ -3,14 +3,17 @@ package extra
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"io"
 	"os"
 	"regexp"
+	"sync"
 	"unicode"
 
 	"github.com/vasilisp/lingograph"
 	"github.com/vasilisp/lingograph/pkg/slicev"
 	"github.com/vasilisp/lingograph/store"
+	"golang.org/x/term"
 	"golang.org/x/text/unicode/norm"
 )
 
@@ -58,24 +61,207 @@ func SanitizeOutputString(input string, removeNewlines bool) string {
 func Echoln(file *os.File, prefix string) func(msg lingograph.Message) {
 	return func(msg lingograph.Message) {
 		SanitizeOutput(prefix, false, file)
-		SanitizeOutput(msg.Content, false, file)
+		SanitizeOutput(msg.DisplayContent(), false, file)
 		file.Write([]byte{'\n'})
 		file.Sync()
 	}
 }
 
+// Echo writes messages with a prefix to a buffered writer. Unlike Echoln, it
+// does not sync the underlying file after each message, so Flush (or Close)
+// has to be called when a run ends, e.g., with lingograph.Finally.
+type Echo struct {
+	mu     sync.Mutex
+	file   io.Writer
+	writer *bufio.Writer
+	prefix string
+	closed bool
+}
+
+// NewEcho creates an Echo writing to w. Its Echo method can be used as an
+// "echo" callback in pipelines.
+func NewEcho(w io.Writer, prefix string) *Echo {
+	return &Echo{file: w, writer: bufio.NewWriter(w), prefix: prefix}
+}
+
+// Echo writes msg to the buffer. Messages echoed after Close are dropped.
+func (e *Echo) Echo(msg lingograph.Message) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return
+	}
+
+	SanitizeOutput(e.prefix, false, e.writer)
+	SanitizeOutput(msg.DisplayContent(), false, e.writer)
+	e.writer.WriteByte('\n')
+}
+
+// Flush writes the buffered messages to the underlying writer, and syncs it if
+// it is a file.
+func (e *Echo) Flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.flush()
+}
+
+func (e *Echo) flush() error {
+	if err := e.writer.Flush(); err != nil {
+		return err
+	}
+
+	if file, ok := e.file.(*os.File); ok {
+		// syncing a terminal or pipe fails harmlessly
+		file.Sync()
+	}
+
+	return nil
+}
+
+// Close flushes the Echo. The underlying writer is not closed.
+func (e *Echo) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+
+	return e.flush()
+}
+
 // Stdin returns an Actor that reads input from standard input.
 // The actor reads a single line of text from stdin and records it as a chat
 // message for downstream processing.
 func Stdin() lingograph.Actor {
-	return lingograph.NewActor(lingograph.User, func(history slicev.RO[lingograph.Message], r store.Store) (string, error) {
+	return StdinPrompt("")
+}
+
+// stdinLine is a line read from standard input, with its line ending.
+type stdinLine struct {
+	text string
+	err  error
+}
+
+// stdinReader reads the lines of standard input on demand. A single goroutine
+// reads them, so that a read abandoned because its context is done does not
+// lose a line; the line goes to the next reader instead. No line is read
+// before a reader asks for one.
+type stdinReader struct {
+	// turn serializes the readers
+	turn chan struct{}
+	// requests asks the goroutine for a line
+	requests chan struct{}
+	lines    chan stdinLine
+	// pending is set while a requested line is not received yet; it is
+	// guarded by turn
+	pending bool
+}
+
+var stdin = sync.OnceValue(func() *stdinReader {
+	s := &stdinReader{
+		turn:     make(chan struct{}, 1),
+		requests: make(chan struct{}),
+		lines:    make(chan stdinLine),
+	}
+
+	go func() {
 		reader := bufio.NewReader(os.Stdin)
 
-		text, err := reader.ReadString('\n')
+		// after the first error, the error is delivered to every reader
+		var errRead error
+
+		for range s.requests {
+			if errRead != nil {
+				s.lines <- stdinLine{err: errRead}
+				continue
+			}
+
+			text, err := reader.ReadString('\n')
+			if err != nil {
+				errRead = err
+				// the text before the error is a line of its own
+				if text == "" {
+					s.lines <- stdinLine{err: err}
+					continue
+				}
+			}
+
+			s.lines <- stdinLine{text: text}
+		}
+	}()
+
+	return s
+})
+
+// readLine returns the next line of standard input, or the error of ctx if it
+// is done first.
+func (s *stdinReader) readLine(ctx context.Context) (stdinLine, error) {
+	select {
+	case s.turn <- struct{}{}:
+	case <-ctx.Done():
+		return stdinLine{}, ctx.Err()
+	}
+	defer func() { <-s.turn }()
+
+	if !s.pending {
+		s.requests <- struct{}{}
+		s.pending = true
+	}
+
+	select {
+	case line := <-s.lines:
+		s.pending = false
+		return line, nil
+	case <-ctx.Done():
+		return stdinLine{}, ctx.Err()
+	}
+}
+
+// StdinPrompt returns an Actor that behaves like Stdin, but first writes the
+// given prompt to standard error. The prompt is not recorded in the chat
+// history. The actor stops waiting when the context of the chat is done, e.g.,
+// under lingograph.WithIdleTimeout.
+func StdinPrompt(prompt string) lingograph.Actor {
+	return lingograph.NewActorContext(lingograph.User, func(ctx context.Context, history slicev.RO[lingograph.Message], r store.Store, emit func(lingograph.Event)) ([]lingograph.Message, error) {
+		if prompt != "" {
+			SanitizeOutput(prompt, false, os.Stderr)
+		}
+
+		line, err := stdin().readLine(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if line.err != nil {
+			return nil, line.err
+		}
+
+		return []lingograph.Message{{Role: lingograph.User, Content: line.text}}, nil
+	})
+}
+
+// Readline returns an Actor that reads a line of input from standard input,
+// showing the given prompt on standard error. When standard input is a
+// terminal, the line can be edited, and previous lines of the session can be
+// recalled with the arrow keys. Otherwise, Readline behaves like StdinPrompt.
+func Readline(prompt string) lingograph.Actor {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return StdinPrompt(prompt)
+	}
+
+	terminal := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stderr}, prompt)
+
+	return lingograph.NewActor(lingograph.User, func(history slicev.RO[lingograph.Message], r store.Store) (string, error) {
+		state, err := term.MakeRaw(fd)
 		if err != nil {
 			return "", err
 		}
+		defer term.Restore(fd, state)
 
-		return text, nil
+		return terminal.ReadLine()
 	})
 }
@@ -0,0 +1,46 @@
+package lingograph
+
+import "sync"
+
+// Variant is a labeled Pipeline, e.g., a prompt variant in an A/B comparison.
+type Variant struct {
+	Label    string
+	Pipeline Pipeline
+}
+
+// ForkResult is the outcome of executing a Variant (see Fork).
+type ForkResult struct {
+	Label string
+	// Chat is the branch the variant was executed on.
+	Chat Chat
+	// Messages are the messages written by the variant.
+	Messages []Message
+	Err      error
+}
+
+// Fork executes each variant concurrently on a separate branch of chat,
+// starting from a snapshot of its history, store and metadata, and returns
+// the results in the order of the variants. Unlike Parallel, the branches are
+// not merged: chat is not modified, and each result keeps its label and
+// output, e.g., for comparing prompts side by side.
+func Fork(chat Chat, variants ...Variant) []ForkResult {
+	results := make([]ForkResult, len(variants))
+
+	var wg sync.WaitGroup
+
+	for i, variant := range variants {
+		branch := branchChat(chat)
+		results[i] = ForkResult{Label: variant.Label, Chat: branch}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			results[i].Messages, results[i].Err = ExecuteMessages(variant.Pipeline, branch)
+		}()
+	}
+
+	wg.Wait()
+
+	return results
+}
@@ -6,6 +6,7 @@ require (
 	github.com/invopop/jsonschema v0.13.0
 	github.com/openai/openai-go v1.12.0
 	github.com/wk8/go-ordered-map/v2 v2.1.8
+	golang.org/x/term v0.34.0
 	golang.org/x/text v0.28.0
 )
 
@@ -20,6 +21,7 @@ require (
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
 	github.com/tidwall/sjson v1.2.5 // indirect
+	golang.org/x/sys v0.35.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,102 @@
+package lingograph
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/vasilisp/lingograph/pkg/slicev"
+	"github.com/vasilisp/lingograph/store"
+)
+
+// ErrIdleTimeout is the error of an Actor wrapped by WithIdleTimeout that did
+// not respond in time.
+var ErrIdleTimeout = errors.New("idle timeout")
+
+// WithIdleTimeout creates an Actor that fails with ErrIdleTimeout if actor
+// does not respond within timeout, e.g., an input actor (see extra.Stdin)
+// waiting for a user who went away. On timeout, the context of actor is
+// cancelled; actors created with NewActorContext, e.g., extra.Stdin, stop
+// waiting, and a line typed later goes to the next read. Other actors, e.g.,
+// extra.Readline on a terminal, keep running in the background, and their
+// messages are discarded; they may still set store variables. ErrIdleTimeout
+// is not retried. If the context of the chat is done first, its error is
+// returned instead.
+//
+// To end a session after a period of inactivity, wrap the input actor of a
+// While loop, and treat ErrIdleTimeout as a normal end:
+//
+//	err := While(cond, Chain(WithIdleTimeout(input, 10*time.Minute).Pipeline(nil, false, 1), ...)).Execute(chat)
+//	if errors.Is(err, ErrIdleTimeout) { ... }
+func WithIdleTimeout(actor Actor, timeout time.Duration) Actor {
+	pipeline := actor.Pipeline(nil, false, 1)
+
+	fn := func(parent context.Context, history slicev.RO[Message], r store.Store, emit func(Event)) ([]Message, error) {
+		type result struct {
+			messages []Message
+			err      error
+		}
+
+		ctx, cancel := context.WithTimeout(parent, timeout)
+		defer cancel()
+
+		done := make(chan result, 1)
+
+		go func() {
+			messages := make([]Message, history.Len())
+			history.CopyTo(messages)
+
+			scratch := &chat{history: messages, storeImpl: r, onEvent: forwardEvents(emit), meta: newMetadata(), ctx: ctx, unbounded: true}
+
+			written, err := ExecuteMessages(pipeline, scratch)
+			done <- result{messages: written, err: err}
+		}()
+
+		// an actor interrupted by the timeout fails with the error of ctx
+		timedOut := func() error {
+			if err := parent.Err(); err != nil {
+				return err
+			}
+			return ErrIdleTimeout
+		}
+
+		select {
+		case result := <-done:
+			if result.err != nil && ctx.Err() != nil {
+				return nil, timedOut()
+			}
+			return result.messages, result.err
+		case <-ctx.Done():
+			return nil, timedOut()
+		}
+	}
+
+	retry := retryPredicateOf(actor)
+
+	return WithRetryPredicate(NewActorVariant(actor, User, fn), func(err error) bool {
+		if errors.Is(err, ErrIdleTimeout) {
+			return false
+		}
+		return retry == nil || retry(err)
+	})
+}
+
+// ActiveWithin creates a HistoryCondition that holds as long as the last user
+// message was written less than d ago, there is no user message yet, or the
+// time of the last user message is unknown, e.g., after openai.ImportMessages (see
+// Message.Time). It is evaluated between iterations, so in a loop with a
+// blocking input actor, it cannot end a session while the actor waits; use
+// WithIdleTimeout for that.
+func ActiveWithin(d time.Duration) HistoryCondition {
+	return func(history slicev.RO[Message], _ store.StoreRO) bool {
+		for i := history.Len() - 1; i >= 0; i-- {
+			if message := history.At(i); message.Role == User {
+				if message.Time.IsZero() {
+					return true
+				}
+				return time.Since(message.Time) < d
+			}
+		}
+		return true
+	}
+}
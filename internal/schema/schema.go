@@ -0,0 +1,250 @@
+// Package schema converts Go types to the JSON schemas expected by model APIs
+// for function parameters.
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+// Reflect returns the JSON schema of T, with references inlined, as a map
+// (see ToMap). It will exit if the schema cannot be converted.
+func Reflect[T any]() map[string]any {
+	var zero T
+	reflector := &jsonschema.Reflector{}
+	reflected := reflector.Reflect(&zero)
+
+	inlinedSchema, err := inlineRefs(reflected)
+	if err != nil {
+		log.Fatalf("cannot inline schema: %s", err)
+	}
+
+	optionalPointers(inlinedSchema, reflect.TypeOf(zero))
+
+	schema, err := ToMap(inlinedSchema)
+	if err != nil {
+		log.Fatalf("cannot convert schema: %s", err)
+	}
+
+	return schema
+}
+
+func inlineRefs(s *jsonschema.Schema) (*jsonschema.Schema, error) {
+	if s.Ref != "" {
+		if s.Definitions == nil {
+			return nil, fmt.Errorf("schema has $ref but no definitions")
+		}
+
+		refKey, err := extractDefKey(s.Ref)
+		if err != nil {
+			return nil, err
+		}
+
+		def, ok := s.Definitions[refKey]
+		if !ok {
+			return nil, fmt.Errorf("ref %q not found in definitions", refKey)
+		}
+
+		return inlineSchema(def, s.Definitions)
+	}
+
+	return inlineSchema(s, s.Definitions)
+}
+
+func inlineSchema(s *jsonschema.Schema, defs map[string]*jsonschema.Schema) (*jsonschema.Schema, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	// Deep copy first
+	copy := *s
+
+	copy.Definitions = nil // Remove defs to match OpenAI expectations
+
+	// Inline all properties
+	if copy.Properties != nil {
+		copy.Properties = orderedmap.New[string, *jsonschema.Schema]()
+		for pair := s.Properties.Oldest(); pair != nil; pair = pair.Next() {
+			inlinedProp, err := inlineSchema(resolveRef(pair.Value, defs), defs)
+			if err != nil {
+				return nil, err
+			}
+			copy.Properties.Set(pair.Key, inlinedProp)
+		}
+	}
+
+	// Inline items (for arrays)
+	if s.Items != nil {
+		inlinedItem, err := inlineSchema(resolveRef(s.Items, defs), defs)
+		if err != nil {
+			return nil, err
+		}
+		copy.Items = inlinedItem
+	}
+
+	return &copy, nil
+}
+
+func resolveRef(s *jsonschema.Schema, defs map[string]*jsonschema.Schema) *jsonschema.Schema {
+	if s == nil || s.Ref == "" {
+		return s
+	}
+
+	refKey, err := extractDefKey(s.Ref)
+	if err != nil {
+		return s
+	}
+
+	def, ok := defs[refKey]
+	if !ok {
+		return s
+	}
+
+	// a description next to the $ref (e.g., from a field tag) takes
+	// precedence over the one of the referenced type
+	if s.Description != "" {
+		resolved := *def
+		resolved.Description = s.Description
+		return &resolved
+	}
+
+	return def
+}
+
+func extractDefKey(ref string) (string, error) {
+	const prefix = "#/$defs/"
+	if len(ref) <= len(prefix) || ref[:len(prefix)] != prefix {
+		return "", fmt.Errorf("unsupported ref format: %s", ref)
+	}
+	return ref[len(prefix):], nil
+}
+
+// jsonFieldName returns the JSON name of a struct field, or "" if the field is
+// not serialized.
+func jsonFieldName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return field.Name
+	}
+
+	return name
+}
+
+// optionalPointers removes pointer fields from the required properties of an
+// inlined schema reflected from t, since a nil pointer is a valid value.
+func optionalPointers(s *jsonschema.Schema, t reflect.Type) {
+	if s == nil || t == nil {
+		return
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		optionalPointers(s.Items, t.Elem())
+	case reflect.Struct:
+		for i := range t.NumField() {
+			field := t.Field(i)
+
+			if field.Anonymous && field.Tag.Get("json") == "" {
+				// embedded struct fields are flattened into the parent
+				optionalPointers(s, field.Type)
+				continue
+			}
+
+			name := jsonFieldName(field)
+			if name == "" {
+				continue
+			}
+
+			if field.Type.Kind() == reflect.Pointer {
+				// copy, since inlined schemas share slices with definitions
+				s.Required = slices.DeleteFunc(slices.Clone(s.Required), func(required string) bool {
+					return required == name
+				})
+			}
+
+			if s.Properties != nil {
+				if prop, ok := s.Properties.Get(name); ok {
+					optionalPointers(prop, field.Type)
+				}
+			}
+		}
+	}
+}
+
+// ToMap converts a jsonschema.Schema to a map in the format expected for
+// function parameters by model APIs. It handles properties, arrays, enums, and
+// other schema features.
+func ToMap(s *jsonschema.Schema) (map[string]any, error) {
+	if s == nil {
+		return nil, errors.New("schema is nil")
+	}
+
+	out := map[string]any{}
+	if s.Type != "" {
+		out["type"] = s.Type
+	}
+
+	if s.Description != "" {
+		out["description"] = s.Description
+	}
+
+	if len(s.Required) > 0 {
+		out["required"] = s.Required
+	}
+
+	if s.AdditionalProperties == jsonschema.FalseSchema {
+		out["additionalProperties"] = false
+	}
+
+	// Handle properties
+	if s.Properties != nil && s.Properties.Len() > 0 {
+		props := map[string]any{}
+		for pair := s.Properties.Oldest(); pair != nil; pair = pair.Next() {
+			name := pair.Key
+			prop := pair.Value
+			inlined, err := ToMap(prop)
+			if err != nil {
+				return nil, err
+			}
+			props[name] = inlined
+		}
+		out["properties"] = props
+	}
+
+	// Handle array items
+	if s.Type == "array" && s.Items != nil {
+		items, err := ToMap(s.Items)
+		if err != nil {
+			return nil, err
+		}
+		out["items"] = items
+	}
+
+	// Optionally handle enums, formats, etc.
+	if len(s.Enum) > 0 {
+		out["enum"] = s.Enum
+	}
+	if s.Format != "" {
+		out["format"] = s.Format
+	}
+
+	return out, nil
+}
@@ -0,0 +1,111 @@
+package schema
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+type pointerAndTime struct {
+	Count *int      `json:"count"`
+	When  time.Time `json:"when"`
+	Name  string    `json:"name"`
+}
+
+func property(t *testing.T, schema map[string]any, name string) map[string]any {
+	t.Helper()
+
+	properties, ok := schema["properties"].(map[string]any)
+	if !ok {
+		t.Fatalf("schema has no properties: %v", schema)
+	}
+
+	prop, ok := properties[name].(map[string]any)
+	if !ok {
+		t.Fatalf("schema has no property %s: %v", name, properties)
+	}
+
+	return prop
+}
+
+func TestReflectPointerAndTime(t *testing.T) {
+	schema := Reflect[pointerAndTime]()
+	required, _ := schema["required"].([]string)
+
+	tests := []struct {
+		name     string
+		typ      string
+		format   string
+		required bool
+	}{
+		{name: "count", typ: "integer", required: false},
+		{name: "when", typ: "string", format: "date-time", required: true},
+		{name: "name", typ: "string", required: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prop := property(t, schema, tt.name)
+
+			if prop["type"] != tt.typ {
+				t.Errorf("type = %v, want %s", prop["type"], tt.typ)
+			}
+
+			if format, _ := prop["format"].(string); format != tt.format {
+				t.Errorf("format = %q, want %q", format, tt.format)
+			}
+
+			if got := slices.Contains(required, tt.name); got != tt.required {
+				t.Errorf("required = %v, want %v", got, tt.required)
+			}
+		})
+	}
+}
+
+type address struct {
+	City string `json:"city" jsonschema:"description=City name"`
+}
+
+type described struct {
+	Query   string    `json:"query" jsonschema:"description=Search query"`
+	Address address   `json:"address" jsonschema:"description=Where to search"`
+	Stops   []address `json:"stops"`
+}
+
+func TestReflectDescriptions(t *testing.T) {
+	schema := Reflect[described]()
+
+	tests := []struct {
+		name        string
+		path        []string
+		description string
+	}{
+		{name: "plain field", path: []string{"query"}, description: "Search query"},
+		{name: "field of inlined type", path: []string{"address"}, description: "Where to search"},
+		{name: "nested field", path: []string{"address", "city"}, description: "City name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prop := schema
+			for _, name := range tt.path {
+				prop = property(t, prop, name)
+			}
+
+			if prop["description"] != tt.description {
+				t.Errorf("description = %v, want %q", prop["description"], tt.description)
+			}
+		})
+	}
+
+	t.Run("array items", func(t *testing.T) {
+		items, ok := property(t, schema, "stops")["items"].(map[string]any)
+		if !ok {
+			t.Fatal("stops has no items")
+		}
+
+		if city := property(t, items, "city"); city["description"] != "City name" {
+			t.Errorf("description = %v, want %q", city["description"], "City name")
+		}
+	})
+}
@@ -1,7 +1,12 @@
 package lingograph
 
 import (
+	"context"
+	"errors"
+	"maps"
 	"math"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,6 +25,15 @@ const (
 	User Role = iota
 	Assistant
 	Function
+	// Developer messages hold instructions, e.g., for injecting instructions
+	// mid-conversation. Providers without a developer role send them as
+	// system or user messages.
+	Developer
+	// System messages hold system prompts that are part of the history,
+	// e.g., for conversations with several system prompts, in addition to
+	// the system prompt of the actor. Providers with a separate system prompt
+	// append them to it.
+	System
 )
 
 func (r Role) String() string {
@@ -30,78 +44,411 @@ func (r Role) String() string {
 		return "assistant"
 	case Function:
 		return "function"
+	case Developer:
+		return "developer"
+	case System:
+		return "system"
 	}
 	return "unknown"
 }
 
 type actorID uint32
 
+// PartKind is the kind of a ContentPart.
+type PartKind uint8
+
+const (
+	PartText PartKind = iota
+	PartImage
+	PartAudio
+)
+
+// ContentPart is a part of multi-part message content.
+type ContentPart struct {
+	Kind PartKind
+	// Text is the content of text parts.
+	Text string
+	// URL is the location of image parts. Data URLs are allowed.
+	URL string
+	// Data is the raw content of audio parts.
+	Data []byte
+	// Format is the format of audio parts, e.g., "wav" or "mp3".
+	Format string
+}
+
+// TextPart creates a text ContentPart.
+func TextPart(text string) ContentPart {
+	return ContentPart{Kind: PartText, Text: text}
+}
+
+// ImagePart creates an image ContentPart referring to the given URL.
+func ImagePart(url string) ContentPart {
+	return ContentPart{Kind: PartImage, URL: url}
+}
+
+// AudioPart creates an audio ContentPart with the given data and format.
+func AudioPart(data []byte, format string) ContentPart {
+	return ContentPart{Kind: PartAudio, Data: data, Format: format}
+}
+
 // Message represents a single message in a conversation with its role and
 // content. The ModelMetadata field can be used to store model-specific
-// metadata.
+// metadata. Parts optionally holds multi-part content, e.g., images. In that
+// case, Content holds the concatenation of the text parts, so that text-only
+// consumers keep working.
 type Message struct {
 	Role          Role
 	Content       string
+	Parts         []ContentPart
 	actor         actorID
 	ModelMetadata any
+	// Pinned messages survive the automatic trimming of long histories.
+	Pinned bool
+	// Display optionally holds a version of the content meant for people,
+	// e.g., a table instead of JSON, while the model sees Content.
+	Display string
+	// Time is when the message was written to the chat, unless it was set
+	// before, e.g., for imported messages.
+	Time time.Time
+}
+
+// DisplayContent returns the content of the message to show to people:
+// Display if set, and Content otherwise.
+func (m Message) DisplayContent() string {
+	if m.Display != "" {
+		return m.Display
+	}
+	return m.Content
+}
+
+// NewMessageParts creates a Message with multi-part content. The Content of the
+// message is the concatenation of the text parts.
+func NewMessageParts(role Role, parts ...ContentPart) Message {
+	var b strings.Builder
+	for _, part := range parts {
+		if part.Kind == PartText {
+			b.WriteString(part.Text)
+		}
+	}
+
+	return Message{Role: role, Content: b.String(), Parts: parts}
+}
+
+// IsFrom reports whether the message was written by the given actor. Actors
+// implemented outside of this package have no identity, so their messages are
+// never attributed to them.
+func (m Message) IsFrom(a Actor) bool {
+	id, ok := identityOf(a)
+	return ok && m.actor == id
 }
 
 // Chat describes the state of a conversation.
 type Chat interface {
 	// History returns the history of the conversation as a read-only slice.
+	// The slice is a snapshot: later writes to the chat are not visible in
+	// it. It does not copy the history, so it is cheap to call.
 	History() slicev.RO[Message]
+	// SetMeta sets a metadata entry of the conversation, e.g., a user ID or a
+	// session tag. Metadata is bookkeeping for filtering and export, separate
+	// from the history and the store.
+	SetMeta(key, value string)
+	// Meta returns a copy of the metadata of the conversation.
+	Meta() map[string]string
+	// Stats returns the counters of the history of the conversation.
+	Stats() Stats
 
 	write(message Message)
+	// edit replaces the last message of the history for which find holds,
+	// and reports whether there was one.
+	edit(find func(Message) bool, message Message) bool
 	trim()
 	store() store.Store
+	record(event Event)
+	metadata() *metadata
+	context() context.Context
+	// sequential reports whether Parallel executes its branches one at a
+	// time (see WithSequentialParallel)
+	sequential() bool
+	// trimming returns whether the history is unbounded (see
+	// WithUnboundedHistory) and the TrimStrategy of the chat, if any
+	trimming() (bool, TrimStrategy)
+}
+
+// Stats holds counters of the history of a Chat, e.g., for tuning trimming.
+// Messages merged from the branches of Parallel count as written to the parent
+// chat when they are merged.
+type Stats struct {
+	// Writes is the number of messages written.
+	Writes int
+	// Trims is the number of times the history was trimmed, explicitly or
+	// automatically because it grew too long.
+	Trims int
+	// AutoTrims is the number of automatic trims, included in Trims.
+	AutoTrims int
+	// Dropped is the number of messages removed by trims.
+	Dropped int
+	// PeakLength is the maximum length of the history.
+	PeakLength int
+	// MeanLength is the mean length of the history after each write.
+	MeanLength float64
 }
 
 type chat struct {
 	history      []Message
 	storeImpl    store.Store
 	offsetUnique int
+	onEvent      func(Event)
+	meta         *metadata
+	// unbounded disables the automatic trimming of long histories
+	unbounded bool
+	// trimStrategy trims long histories; nil means DefaultTrimStrategy
+	trimStrategy TrimStrategy
+	stats        Stats
+	// lengthSum is the sum of the lengths of the history after each write
+	lengthSum int
+	// ctx cancels the waiting of pipelines; nil means context.Background()
+	ctx context.Context
+	// sequentialParallel makes Parallel execute its branches one at a time
+	sequentialParallel bool
 }
 
+// History returns a view of the current history. The view is a snapshot
+// without copying, since the chat never modifies the elements of its history
+// in place: writes append past the length of the view, and trimming replaces
+// the history.
 func (c *chat) History() slicev.RO[Message] {
 	return slicev.NewRO(c.history)
 }
 
 func (c *chat) write(message Message) {
-	if len(c.history) >= maxHistoryLength {
-		keep := maxHistoryLength / 2
-		if keep < c.offsetUnique {
-			c.offsetUnique = 0
-		} else {
-			c.offsetUnique -= len(c.history) - keep
+	if message.Time.IsZero() {
+		message.Time = time.Now()
+	}
+
+	c.record(Event{Kind: EventWrite, Message: message})
+
+	if !c.unbounded {
+		strategy := c.trimStrategy
+		if strategy == nil {
+			strategy = DefaultTrimStrategy
 		}
-		c.history = c.history[len(c.history)-keep:]
+
+		// capping the capacity keeps strategies that append from writing to
+		// the backing array of the views returned by History
+		// the strategy may also replace messages, e.g., with a summary, so
+		// its result is used even if the length is unchanged
+		history := strategy(c.history[:len(c.history):len(c.history)])
+		if len(history) < len(c.history) {
+			c.stats.Trims++
+			c.stats.AutoTrims++
+			c.stats.Dropped += len(c.history) - len(history)
+		}
+
+		c.history = history
+		// bounded chats are never split chats, so all their messages are
+		// unique
+		c.offsetUnique = 0
 	}
 	c.history = append(c.history, message)
+
+	c.stats.Writes++
+	c.stats.PeakLength = max(c.stats.PeakLength, len(c.history))
+	c.lengthSum += len(c.history)
+}
+
+func (c *chat) edit(find func(Message) bool, message Message) bool {
+	for i := len(c.history) - 1; i >= 0; i-- {
+		if !find(c.history[i]) {
+			continue
+		}
+
+		c.record(Event{Kind: EventEdit, Message: message})
+
+		// copy, so that the views returned by History stay unchanged
+		history := slices.Clone(c.history)
+		history[i] = message
+		c.history = history
+
+		return true
+	}
+
+	return false
 }
 
 func (c *chat) trim() {
+	c.record(Event{Kind: EventTrim})
+
+	c.stats.Trims++
+	c.stats.Dropped += len(c.history)
+
 	c.history = make([]Message, 0)
 	c.offsetUnique = 0
 }
 
+func (c *chat) Stats() Stats {
+	stats := c.stats
+	if stats.Writes > 0 {
+		stats.MeanLength = float64(c.lengthSum) / float64(stats.Writes)
+	}
+	return stats
+}
+
 func (c *chat) store() store.Store {
 	return c.storeImpl
 }
 
+func (c *chat) record(event Event) {
+	if c.onEvent != nil {
+		c.onEvent(event)
+	}
+}
+
+func (c *chat) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+func (c *chat) sequential() bool {
+	return c.sequentialParallel
+}
+
+func (c *chat) trimming() (bool, TrimStrategy) {
+	return c.unbounded, c.trimStrategy
+}
+
 func (c *chat) uniqueMessages() []Message {
 	return c.history[c.offsetUnique:]
 }
 
+// ChatOption configures a Chat created by NewChat or NewRecordingChat.
+type ChatOption func(*chat)
+
+// WithUnboundedHistory disables the automatic trimming of long histories (see
+// TrimStrategy). With an unbounded history, the caller is responsible for
+// keeping the history within the context window of the model, e.g., by
+// trimming or summarizing it, or requests will fail.
+func WithUnboundedHistory() ChatOption {
+	return func(c *chat) {
+		c.unbounded = true
+	}
+}
+
+// TrimStrategy decides which messages of the history of a Chat to keep, e.g.,
+// to stay within the context window of a model. It is called with the history
+// before every write, and returns it unchanged if there is no need to trim.
+// Otherwise, it returns the messages to keep, in order; it may also replace
+// messages, e.g., with a summary, but it must not modify history in place.
+// Providers expect the function messages that follow an assistant message to
+// stay with it (see Role), and pinned messages are meant to be kept.
+type TrimStrategy func(history []Message) []Message
+
+// DefaultTrimStrategy is the TrimStrategy of chats, unless configured
+// otherwise: when the history reaches 1000 messages, the oldest half is
+// dropped, except for the pinned messages.
+func DefaultTrimStrategy(history []Message) []Message {
+	if len(history) < maxHistoryLength {
+		return history
+	}
+
+	// drop the oldest messages, except for the pinned ones
+	drop := len(history) - maxHistoryLength/2
+	kept := make([]Message, 0, maxHistoryLength)
+
+	for i, m := range history {
+		if i < drop && !m.Pinned {
+			continue
+		}
+		kept = append(kept, m)
+	}
+
+	return kept
+}
+
+// WithTrimStrategy replaces DefaultTrimStrategy as the strategy for trimming
+// long histories, e.g., with one based on a token budget, or one that keeps
+// assistant messages together with their function results.
+func WithTrimStrategy(strategy TrimStrategy) ChatOption {
+	util.Assert(strategy != nil, "WithTrimStrategy nil strategy")
+
+	return func(c *chat) {
+		c.trimStrategy = strategy
+	}
+}
+
+// WithChatContext sets a context for the pipelines executing on the chat.
+// Once ctx is done, pipelines that wait between iterations, e.g., Every and
+// WhileBackoff, stop waiting and fail with the error of ctx, and so do the
+// requests of the providers and the actors created with NewActorContext.
+func WithChatContext(ctx context.Context) ChatOption {
+	util.Assert(ctx != nil, "WithChatContext nil ctx")
+
+	return func(c *chat) {
+		c.ctx = ctx
+	}
+}
+
+// WithSequentialParallel makes the Parallel pipelines executing on the chat
+// execute their branches sequentially, in order, instead of concurrently,
+// e.g., for reproducible tests. The branches still run on separate splits of
+// the chat, and all of them run even if one fails, so the result is the same
+// as with concurrent execution, except for the interleaving of store writes
+// and other time-dependent behavior.
+func WithSequentialParallel() ChatOption {
+	return func(c *chat) {
+		c.sequentialParallel = true
+	}
+}
+
+// wait waits for d, or until ctx is done, in which case it returns the error
+// of ctx. Non-positive durations do not wait.
+func wait(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // NewChat creates and returns a new Chat instance with an empty history
 // and a fresh store.
-func NewChat() Chat {
-	return &chat{history: make([]Message, 0), storeImpl: store.NewStore(), offsetUnique: 0}
+func NewChat(options ...ChatOption) Chat {
+	c := &chat{history: make([]Message, 0), storeImpl: store.NewStore(), offsetUnique: 0, meta: newMetadata()}
+	for _, option := range options {
+		option(c)
+	}
+	return c
 }
 
 const userActorID actorID = 0
 
+// systemActorID is the identity of the messages written by SystemPrompt;
+// actors are numbered from 1 up, so it is never the identity of an Actor
+const systemActorID actorID = math.MaxUint32
+
 var lastActorID uint32 = 0
 
+// ResetActorIDs restarts the numbering of actors, so that actors created
+// afterwards get the same identities in every run, e.g., in tests. Actors
+// created before the reset must not be used afterwards, since their
+// identities may collide with the ones of new actors.
+func ResetActorIDs() {
+	atomic.StoreUint32(&lastActorID, 0)
+}
+
 // Pipeline describes a sequence of operations that can be executed on a Chat
 // instance.
 type Pipeline interface {
@@ -109,11 +456,40 @@ type Pipeline interface {
 	trims() bool
 }
 
+type collectingChat struct {
+	Chat
+	written []Message
+}
+
+func (c *collectingChat) write(message Message) {
+	c.written = append(c.written, message)
+	c.Chat.write(message)
+}
+
+// ExecuteN executes the pipeline on the chat like Pipeline.Execute, and also
+// returns the number of messages the pipeline wrote to the chat. Messages
+// that were written and later trimmed by the pipeline are counted.
+func ExecuteN(pipeline Pipeline, chat Chat) (int, error) {
+	messages, err := ExecuteMessages(pipeline, chat)
+	return len(messages), err
+}
+
+// ExecuteMessages executes the pipeline on the chat like Pipeline.Execute, and
+// also returns the messages the pipeline wrote to the chat, in order. Messages
+// that were written and later trimmed by the pipeline are included. On error,
+// the messages written before the error are returned.
+func ExecuteMessages(pipeline Pipeline, chat Chat) ([]Message, error) {
+	collecting := &collectingChat{Chat: chat}
+	err := pipeline.Execute(collecting)
+	return collecting.written, err
+}
+
 type staticPipeline struct {
 	actorID actorID
 	roleID  Role
 	message string
 	trim    bool
+	pinned  bool
 }
 
 func (a *staticPipeline) Execute(chat Chat) error {
@@ -121,7 +497,7 @@ func (a *staticPipeline) Execute(chat Chat) error {
 		chat.trim()
 	}
 
-	chat.write(Message{Role: a.roleID, Content: a.message})
+	chat.write(Message{Role: a.roleID, Content: a.message, Pinned: a.pinned, actor: a.actorID})
 
 	return nil
 }
@@ -136,16 +512,139 @@ func UserPrompt(message string, trim bool) Pipeline {
 	return &staticPipeline{actorID: userActorID, roleID: User, message: message, trim: trim}
 }
 
+// SystemPrompt creates a Pipeline that writes a system message to the chat
+// history. If trim is true, it clears the chat history before writing the
+// message.
+func SystemPrompt(message string, trim bool) Pipeline {
+	return &staticPipeline{actorID: systemActorID, roleID: System, message: message, trim: trim}
+}
+
+// PinnedPrompt creates a Pipeline that writes a pinned user message to the chat
+// history. Unlike other messages, pinned messages are not dropped when a long
+// history is trimmed automatically. They are still cleared by pipelines that
+// trim explicitly.
+func PinnedPrompt(message string) Pipeline {
+	return &staticPipeline{actorID: userActorID, roleID: User, message: message, pinned: true}
+}
+
+type messagesPipeline struct {
+	messages []Message
+	trim     bool
+}
+
+func (p *messagesPipeline) Execute(chat Chat) error {
+	if p.trim {
+		chat.trim()
+	}
+
+	for _, message := range p.messages {
+		chat.write(message)
+	}
+
+	return nil
+}
+
+func (p *messagesPipeline) trims() bool {
+	return p.trim
+}
+
+// Messages creates a Pipeline that writes the given messages to the chat
+// history, e.g., to seed a chat with an imported transcript. If trim is true,
+// it clears the chat history before writing the messages.
+func Messages(messages []Message, trim bool) Pipeline {
+	return &messagesPipeline{messages: messages, trim: trim}
+}
+
 // Actor represents a participant in the conversation that can generate
-// messages based on the chat history and store state.
+// messages based on the chat history and store state. An Actor that wraps
+// another one can implement an Unwrap() Actor method returning it, so that it
+// shares the identity of the wrapped Actor (see Message.IsFrom).
 type Actor interface {
+	// Pipeline creates a Pipeline that writes the messages of the Actor to
+	// the chat. echo, if not nil, is called with every message before it is
+	// written; a nil echo only disables the callback. The messages are
+	// written to the history either way.
 	Pipeline(echo func(Message), trim bool, retryLimit int) Pipeline
 }
 
+// identified is implemented by the Actors of this package that have an
+// identity of their own.
+type identified interface {
+	id() actorID
+}
+
+type unwrapper interface {
+	Unwrap() Actor
+}
+
+// identityOf returns the identity of the Actor, if it has one, following
+// Unwrap.
+func identityOf(a Actor) (actorID, bool) {
+	for a != nil {
+		if i, ok := a.(identified); ok {
+			return i.id(), true
+		}
+
+		u, ok := a.(unwrapper)
+		if !ok {
+			break
+		}
+		a = u.Unwrap()
+	}
+
+	return userActorID, false
+}
+
+// retrying is implemented by the Actors of this package that have a
+// RetryPredicate.
+type retrying interface {
+	retryPredicate() RetryPredicate
+}
+
+// retryPredicateOf returns the RetryPredicate of the Actor, following Unwrap,
+// or nil if it has none.
+func retryPredicateOf(a Actor) RetryPredicate {
+	for a != nil {
+		if r, ok := a.(retrying); ok {
+			return r.retryPredicate()
+		}
+
+		u, ok := a.(unwrapper)
+		if !ok {
+			break
+		}
+		a = u.Unwrap()
+	}
+
+	return nil
+}
+
+func newActorID() actorID {
+	return actorID(atomic.AddUint32(&lastActorID, 1))
+}
+
+// RetryPredicate reports whether a failed attempt of an Actor should be
+// retried, within the retry limit of the Pipeline. Actors without a
+// RetryPredicate retry on every error.
+type RetryPredicate func(error) bool
+
+// RetryAfterError is an error that tells how long to wait before retrying,
+// e.g., the Retry-After header of a rate limited request. Pipelines of Actors
+// wait for RetryAfter (if positive) before retrying an attempt that failed
+// with such an error, instead of backing off exponentially.
+type RetryAfterError interface {
+	error
+	RetryAfter() time.Duration
+}
+
+// actorFunc is the message generation function of an actor
+type actorFunc func(context.Context, slicev.RO[Message], store.Store, func(Event)) ([]Message, error)
+
 type actor struct {
 	actorID actorID
 	roleID  Role
-	fn      func(slicev.RO[Message], store.Store) ([]Message, error)
+	fn      actorFunc
+	retryIf RetryPredicate
 }
 
 // NewActor creates a new Actor with the specified role and message generation function.
@@ -153,7 +652,7 @@ type actor struct {
 func NewActor(role Role, fn func(slicev.RO[Message], store.Store) (string, error)) Actor {
 	util.Assert(fn != nil, "NewActor nil fn")
 
-	fnWrapped := func(history slicev.RO[Message], r store.Store) ([]Message, error) {
+	fnWrapped := func(_ context.Context, history slicev.RO[Message], r store.Store, emit func(Event)) ([]Message, error) {
 		content, err := fn(history, r)
 		if err != nil {
 			return nil, err
@@ -164,7 +663,7 @@ func NewActor(role Role, fn func(slicev.RO[Message], store.Store) (string, error
 	}
 
 	return &actor{
-		actorID: actorID(atomic.AddUint32(&lastActorID, 1)),
+		actorID: newActorID(),
 		roleID:  role,
 		fn:      fnWrapped,
 	}
@@ -175,13 +674,148 @@ func NewActor(role Role, fn func(slicev.RO[Message], store.Store) (string, error
 func NewActorUnsafe(role Role, fn func(slicev.RO[Message], store.Store) ([]Message, error)) Actor {
 	util.Assert(fn != nil, "NewActorUnsafe nil fn")
 
+	return NewActorEmitting(role, func(history slicev.RO[Message], r store.Store, emit func(Event)) ([]Message, error) {
+		return fn(history, r)
+	})
+}
+
+// NewActorEmitting creates a new Actor like NewActorUnsafe, except that fn also
+// receives a function for reporting progress events, e.g., EventToolStart and
+// EventToolEnd. The events reach the observers of the chat (see Observe and
+// NewRecordingChat).
+func NewActorEmitting(role Role, fn func(slicev.RO[Message], store.Store, func(Event)) ([]Message, error)) Actor {
+	util.Assert(fn != nil, "NewActorEmitting nil fn")
+
+	return NewActorContext(role, func(_ context.Context, history slicev.RO[Message], r store.Store, emit func(Event)) ([]Message, error) {
+		return fn(history, r, emit)
+	})
+}
+
+// NewActorContext creates a new Actor like NewActorEmitting, except that fn
+// also receives the context of the chat (see WithChatContext), which is
+// cancelled when the output of the Actor is no longer needed, e.g., by
+// WithIdleTimeout or Timeout. fn should stop waiting, e.g., for user input or a network
+// request, once the context is done. Retries stop as well.
+func NewActorContext(role Role, fn func(context.Context, slicev.RO[Message], store.Store, func(Event)) ([]Message, error)) Actor {
+	util.Assert(fn != nil, "NewActorContext nil fn")
+
 	return &actor{
-		actorID: actorID(atomic.AddUint32(&lastActorID, 1)),
+		actorID: newActorID(),
 		roleID:  role,
 		fn:      fn,
 	}
 }
 
+// EchoActor creates an Actor that repeats the content of the last message in
+// the history, or writes an empty message if the history is empty. It is
+// useful for wiring up and testing pipelines without a model.
+func EchoActor(role Role) Actor {
+	return NewActor(role, func(history slicev.RO[Message], r store.Store) (string, error) {
+		if history.Len() == 0 {
+			return "", nil
+		}
+
+		return history.At(history.Len() - 1).Content, nil
+	})
+}
+
+// NewActorVariant creates a new Actor like NewActorContext, except that it
+// shares its identity and its RetryPredicate with base: the messages it writes
+// count as written by base (see Message.IsFrom). This is useful for
+// implementing per-pipeline settings of an Actor. If base is implemented
+// outside of this package, and thus has no identity, the variant gets a fresh
+// one.
+func NewActorVariant(base Actor, role Role, fn func(context.Context, slicev.RO[Message], store.Store, func(Event)) ([]Message, error)) Actor {
+	util.Assert(base != nil, "NewActorVariant nil base")
+	util.Assert(fn != nil, "NewActorVariant nil fn")
+
+	id, ok := identityOf(base)
+	if !ok {
+		id = newActorID()
+	}
+
+	return &actor{
+		actorID: id,
+		roleID:  role,
+		fn:      fn,
+		retryIf: retryPredicateOf(base),
+	}
+}
+
+// WithRetryPredicate returns a copy of the Actor (with the same identity) that
+// retries only the failed attempts for which retry returns true, e.g., on
+// transient backend errors. A nil retry restores retrying on every error.
+// Actors other than the ones created by this package are wrapped: each
+// attempt executes a Pipeline of a with a retry limit of 1 on a snapshot of
+// the chat, as with Retry, and its retries are decided by retry.
+func WithRetryPredicate(a Actor, retry RetryPredicate) Actor {
+	util.Assert(a != nil, "WithRetryPredicate nil actor")
+
+	switch impl := a.(type) {
+	case *actor:
+		variant := *impl
+		variant.retryIf = retry
+		return &variant
+	case *viewActor:
+		return &viewActor{Actor: WithRetryPredicate(impl.Actor, retry), view: impl.view}
+	case *retryActor:
+		return &retryActor{Actor: impl.Actor, retryIf: retry}
+	}
+
+	return &retryActor{Actor: a, retryIf: retry}
+}
+
+// retryActor overrides the RetryPredicate of an Actor that does not expose
+// its retry loop.
+type retryActor struct {
+	Actor
+	retryIf RetryPredicate
+}
+
+func (a *retryActor) Unwrap() Actor {
+	return a.Actor
+}
+
+func (a *retryActor) retryPredicate() RetryPredicate {
+	return a.retryIf
+}
+
+func (a *retryActor) Pipeline(echo func(Message), trim bool, retryLimit int) Pipeline {
+	return &retry{
+		attempts: max(1, retryLimit),
+		backoff:  actorBackoff,
+		body:     a.Actor.Pipeline(echo, trim, 1),
+		retryIf:  a.retryIf,
+	}
+}
+
+// actorBackoff is the delay after the failed attempt of an Actor with the
+// given number, starting from 1, unless the error tells otherwise (see
+// RetryAfterError).
+func actorBackoff(attempt int) time.Duration {
+	return time.Duration(math.Pow(2, float64(attempt-1))) * time.Second
+}
+
+// retryDelay returns the delay after the failed attempt with the given number
+// (starting from 1): RetryAfter, if err is a RetryAfterError with a positive
+// delay, and backoff(attempt) otherwise.
+func retryDelay(backoff func(int) time.Duration, attempt int, err error) time.Duration {
+	var retryAfter RetryAfterError
+	if errors.As(err, &retryAfter) && retryAfter.RetryAfter() > 0 {
+		return retryAfter.RetryAfter()
+	}
+
+	return backoff(attempt)
+}
+
+func (a *actor) id() actorID {
+	return a.actorID
+}
+
+func (a *actor) retryPredicate() RetryPredicate {
+	return a.retryIf
+}
+
 type actorPipeline struct {
 	actor
 	echo       func(Message)
@@ -208,17 +842,30 @@ func (a *actorPipeline) Execute(chat Chat) error {
 
 	retryLimit := max(1, a.retryLimit)
 
+	emit := func(event Event) {
+		event.actor = a.actorID
+		chat.record(event)
+	}
+
+	ctx := chat.context()
+
 	for i := range retryLimit {
-		newMessages, err = a.fn(history, chat.store())
+		newMessages, err = a.fn(ctx, history, chat.store(), emit)
+		chat.record(Event{Kind: EventActor, Err: err, Attempt: i + 1, RetryLimit: retryLimit, actor: a.actorID})
 		if err == nil {
 			break
 		}
 
 		util.Log.Printf("error executing pipeline: %v", err)
 
+		if (a.retryIf != nil && !a.retryIf(err)) || ctx.Err() != nil {
+			break
+		}
+
 		if i < retryLimit-1 {
-			backoff := time.Duration(math.Pow(2, float64(i))) * time.Second
-			time.Sleep(backoff)
+			if errWait := wait(ctx, retryDelay(actorBackoff, i+1, err)); errWait != nil {
+				return errWait
+			}
 		}
 	}
 	if err != nil {
@@ -276,6 +923,21 @@ func (c *chain) trims() bool {
 	return false
 }
 
+// forwardEvents returns the onEvent function of a scratch chat whose unique
+// messages are merged into a parent chat with the given record function. The
+// writes, edits and trims of the scratch chat are dropped, since the parent
+// records the merged writes itself; other events, e.g., actor invocations,
+// are forwarded.
+func forwardEvents(record func(Event)) func(Event) {
+	return func(event Event) {
+		switch event.Kind {
+		case EventWrite, EventEdit, EventTrim:
+			return
+		}
+		record(event)
+	}
+}
+
 func split(c Chat, nr int) []*chat {
 	splitters := make([]*chat, nr)
 
@@ -288,6 +950,13 @@ func split(c Chat, nr int) []*chat {
 			history:      messages,
 			offsetUnique: len(messages),
 			storeImpl:    c.store(),
+			onEvent:      forwardEvents(c.record),
+			meta:         c.metadata(),
+			ctx:          c.context(),
+			// nested Parallel pipelines are sequential as well
+			sequentialParallel: c.sequential(),
+			// the parent applies its own trimming when merging
+			unbounded: true,
 		}
 	}
 
@@ -296,13 +965,38 @@ func split(c Chat, nr int) []*chat {
 
 type parallel struct {
 	pipelines []Pipeline
+	onResult  func(BranchResult)
+}
+
+// BranchResult is the result of a branch of Parallel (see
+// ParallelWithResults).
+type BranchResult struct {
+	// Index is the position of the branch among the pipelines of Parallel.
+	Index int
+	// Messages are the messages written by the branch.
+	Messages []Message
+	Err      error
 }
 
 // Parallel creates a Pipeline that executes multiple pipelines concurrently.
+// It waits for all the pipelines to finish. If any of them fails, the errors
+// are returned joined (see errors.Join), and no messages are written to the
+// chat.
 func Parallel(pipelines ...Pipeline) Pipeline {
 	return &parallel{pipelines: pipelines}
 }
 
+// ParallelWithResults creates a Pipeline like Parallel, which also passes the
+// result of each branch to onResult as soon as the branch finishes, e.g., for
+// displaying the fastest candidate immediately. The calls of onResult are
+// serialized, in the order the branches finish. The chat still receives the
+// messages of all branches in order, at the end.
+func ParallelWithResults(onResult func(BranchResult), pipelines ...Pipeline) Pipeline {
+	util.Assert(onResult != nil, "ParallelWithResults nil onResult")
+
+	return &parallel{pipelines: pipelines, onResult: onResult}
+}
+
 func (p *parallel) trims() bool {
 	for _, pipeline := range p.pipelines {
 		if !pipeline.trims() {
@@ -319,36 +1013,56 @@ func (p *parallel) Execute(chat Chat) error {
 
 	splitters := split(chat, len(p.pipelines))
 
-	wg := sync.WaitGroup{}
-	wg.Add(len(p.pipelines))
-
 	var mu sync.Mutex
-	var errors []error
+	var errs []error
 
-	fn := func(i int) {
+	// resultMu serializes the calls of onResult
+	var resultMu sync.Mutex
+
+	execute := func(i int) error {
 		splitter := splitters[i]
 		err := p.pipelines[i].Execute(splitter)
-		if err != nil {
-			mu.Lock()
-			errors = append(errors, err)
-			mu.Unlock()
-			return
+
+		if p.onResult != nil {
+			resultMu.Lock()
+			p.onResult(BranchResult{Index: i, Messages: slices.Clone(splitter.uniqueMessages()), Err: err})
+			resultMu.Unlock()
 		}
-		wg.Done()
-	}
 
-	for i := range p.pipelines {
-		go fn(i)
+		return err
 	}
 
-	wg.Wait()
+	if chat.sequential() {
+		for i := range p.pipelines {
+			if err := execute(i); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	} else {
+		wg := sync.WaitGroup{}
+		wg.Add(len(p.pipelines))
+
+		for i := range p.pipelines {
+			go func() {
+				defer wg.Done()
+
+				if err := execute(i); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}()
+		}
 
-	if len(errors) > 0 {
-		for _, err := range errors {
+		wg.Wait()
+	}
+
+	if len(errs) > 0 {
+		for _, err := range errs {
 			util.Log.Printf("error executing pipeline: %v", err)
 		}
 
-		return errors[0]
+		return errors.Join(errs...)
 	}
 
 	if p.trims() {
@@ -365,22 +1079,46 @@ func (p *parallel) Execute(chat Chat) error {
 	return nil
 }
 
-// Condition is a predicate over the store.
+// Condition is a predicate over the store. Combinators evaluate conditions on
+// a point-in-time view of the store (see store.View): a condition sees a Set
+// by a concurrent pipeline, e.g., a branch of Parallel, either fully or not
+// at all, and all the reads of one evaluation see the same state. Sets that
+// happen during the evaluation are seen by the next evaluation.
 type Condition func(store.StoreRO) bool
 
+// HistoryCondition is a predicate over the chat history and the store, e.g.,
+// for testing the content of the last message.
+type HistoryCondition func(slicev.RO[Message], store.StoreRO) bool
+
+func (c Condition) history() HistoryCondition {
+	return func(_ slicev.RO[Message], r store.StoreRO) bool {
+		return c(r)
+	}
+}
+
+func (c HistoryCondition) eval(chat Chat) bool {
+	return c(chat.History(), store.View(chat.store()))
+}
+
 type while struct {
-	condition Condition
+	condition HistoryCondition
 	pipeline  Pipeline
 }
 
 // While creates a Pipeline that repeatedly executes the given pipeline
 // as long as the condition evaluates to true.
 func While(condition Condition, pipeline Pipeline) Pipeline {
+	return WhileHistory(condition.history(), pipeline)
+}
+
+// WhileHistory is like While, but the condition can also inspect the chat
+// history.
+func WhileHistory(condition HistoryCondition, pipeline Pipeline) Pipeline {
 	return &while{pipeline: pipeline, condition: condition}
 }
 
 func (w *while) Execute(chat Chat) error {
-	for w.condition(chat.store().RO()) {
+	for w.condition.eval(chat) {
 		err := w.pipeline.Execute(chat)
 		if err != nil {
 			return err
@@ -394,8 +1132,210 @@ func (w *while) trims() bool {
 	return w.pipeline.trims()
 }
 
-type ifPipeline struct {
+type every struct {
+	interval  time.Duration
+	condition Condition
+	pipeline  Pipeline
+}
+
+// Every creates a Pipeline that repeatedly executes the given pipeline as long
+// as the condition evaluates to true, starting a new iteration at most once per
+// interval. The interval is measured from the start of each iteration, so the
+// execution time of the pipeline counts towards it. Waiting for the next
+// iteration ends early if the context of the chat is done (see
+// WithChatContext), and Every then fails with the error of the context.
+func Every(interval time.Duration, condition Condition, pipeline Pipeline) Pipeline {
+	util.Assert(interval > 0, "Every non-positive interval")
+
+	return &every{interval: interval, condition: condition, pipeline: pipeline}
+}
+
+func (e *every) Execute(chat Chat) error {
+	for e.condition(store.View(chat.store())) {
+		start := time.Now()
+
+		err := e.pipeline.Execute(chat)
+		if err != nil {
+			return err
+		}
+
+		if err := wait(chat.context(), e.interval-time.Since(start)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *every) trims() bool {
+	return e.pipeline.trims()
+}
+
+// Backoff configures the interval between the iterations of WhileBackoff.
+// Zero fields take the values of DefaultBackoff.
+type Backoff struct {
+	// Min is the interval after an iteration that made progress.
+	Min time.Duration
+	// Max caps the interval.
+	Max time.Duration
+	// Factor multiplies the interval after an iteration without progress.
+	Factor float64
+}
+
+// DefaultBackoff starts polling every second, and backs off up to once per
+// minute.
+var DefaultBackoff = Backoff{Min: time.Second, Max: time.Minute, Factor: 2}
+
+func (b Backoff) withDefaults() Backoff {
+	if b.Min <= 0 {
+		b.Min = DefaultBackoff.Min
+	}
+	if b.Max <= 0 {
+		b.Max = max(DefaultBackoff.Max, b.Min)
+	}
+	if b.Factor <= 1 {
+		b.Factor = DefaultBackoff.Factor
+	}
+
+	return b
+}
+
+type whileBackoff struct {
 	condition Condition
+	progress  Condition
+	backoff   Backoff
+	pipeline  Pipeline
+}
+
+// WhileBackoff creates a Pipeline that repeatedly executes the given pipeline
+// as long as the condition evaluates to true, e.g., for polling. After an
+// iteration that made progress, the next one starts after backoff.Min;
+// otherwise, the interval grows by backoff.Factor up to backoff.Max. Progress
+// is signaled through the store, and checked with progress after each
+// iteration. If progress is nil, an iteration made progress if it wrote any
+// messages. As with Every, waiting ends early if the context of the chat is
+// done (see WithChatContext), and WhileBackoff then fails with its error.
+func WhileBackoff(condition Condition, progress Condition, backoff Backoff, pipeline Pipeline) Pipeline {
+	return &whileBackoff{
+		condition: condition,
+		progress:  progress,
+		backoff:   backoff.withDefaults(),
+		pipeline:  pipeline,
+	}
+}
+
+func (w *whileBackoff) Execute(chat Chat) error {
+	interval := w.backoff.Min
+
+	for w.condition(store.View(chat.store())) {
+		written, err := ExecuteN(w.pipeline, chat)
+		if err != nil {
+			return err
+		}
+
+		progress := written > 0
+		if w.progress != nil {
+			progress = w.progress(store.View(chat.store()))
+		}
+
+		if progress {
+			interval = w.backoff.Min
+		} else {
+			interval = min(time.Duration(float64(interval)*w.backoff.Factor), w.backoff.Max)
+		}
+
+		if !w.condition(store.View(chat.store())) {
+			break
+		}
+
+		if err := wait(chat.context(), interval); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *whileBackoff) trims() bool {
+	return w.pipeline.trims()
+}
+
+// FinishCondition reports whether an agentic loop is done, given the last
+// assistant message of an iteration and the store.
+type FinishCondition func(Message, store.StoreRO) bool
+
+type agentLoop struct {
+	pipeline      Pipeline
+	done          FinishCondition
+	maxIterations int
+}
+
+// AgentLoop creates a Pipeline that executes the given pipeline repeatedly,
+// e.g., an actor that calls functions and then reacts to the results. The loop
+// ends when done holds for the last assistant message written by an
+// iteration, when an iteration writes no assistant message, or after
+// maxIterations iterations (if positive). If done is nil, the loop ends when
+// an iteration writes no function messages, i.e., the model made no tool
+// calls.
+func AgentLoop(pipeline Pipeline, done FinishCondition, maxIterations int) Pipeline {
+	return &agentLoop{pipeline: pipeline, done: done, maxIterations: maxIterations}
+}
+
+func (l *agentLoop) Execute(chat Chat) error {
+	for i := 0; l.maxIterations <= 0 || i < l.maxIterations; i++ {
+		// the observer is called concurrently by the branches of Parallel
+		var mu sync.Mutex
+		var last *Message
+		wroteFunction := false
+
+		observer := func(event Event) {
+			if event.Kind != EventWrite {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch event.Message.Role {
+			case Assistant:
+				message := event.Message
+				last = &message
+			case Function:
+				wroteFunction = true
+			}
+		}
+
+		err := l.pipeline.Execute(&observedChat{Chat: chat, observer: observer})
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		lastMessage, wrote := last, wroteFunction
+		mu.Unlock()
+
+		if lastMessage == nil {
+			return nil
+		}
+
+		if l.done == nil {
+			if !wrote {
+				return nil
+			}
+		} else if l.done(*lastMessage, store.View(chat.store())) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (l *agentLoop) trims() bool {
+	return l.pipeline.trims()
+}
+
+type ifPipeline struct {
+	condition HistoryCondition
 	left      Pipeline
 	right     Pipeline
 }
@@ -403,11 +1343,16 @@ type ifPipeline struct {
 // If creates a Pipeline that executes either the left or right pipeline
 // based on the condition.
 func If(condition Condition, left Pipeline, right Pipeline) Pipeline {
+	return IfHistory(condition.history(), left, right)
+}
+
+// IfHistory is like If, but the condition can also inspect the chat history.
+func IfHistory(condition HistoryCondition, left Pipeline, right Pipeline) Pipeline {
 	return &ifPipeline{condition: condition, left: left, right: right}
 }
 
 func (p *ifPipeline) Execute(chat Chat) error {
-	if p.condition(chat.store().RO()) {
+	if p.condition.eval(chat) {
 		return p.left.Execute(chat)
 	}
 	return p.right.Execute(chat)
@@ -418,6 +1363,473 @@ func (p *ifPipeline) trims() bool {
 	return p.left.trims() && p.right.trims()
 }
 
+type switchPipeline[T comparable] struct {
+	v        store.Var[T]
+	cases    map[T]Pipeline
+	fallback Pipeline
+}
+
+// Switch creates a Pipeline that reads v from the store and executes the
+// pipeline of cases for its value, e.g., for routing the output of a
+// classifier to one of several handlers. If v is not set, or there is no case
+// for its value, it executes fallback, which may be nil for doing nothing.
+// The cases are copied, so later changes to the map are not seen.
+func Switch[T comparable](v store.Var[T], cases map[T]Pipeline, fallback Pipeline) Pipeline {
+	return &switchPipeline[T]{v: v, cases: maps.Clone(cases), fallback: fallback}
+}
+
+func (p *switchPipeline[T]) Execute(chat Chat) error {
+	pipeline := p.fallback
+
+	if value, ok := store.GetRO(store.View(chat.store()), p.v); ok {
+		if c, ok := p.cases[value]; ok {
+			pipeline = c
+		}
+	}
+
+	if pipeline == nil {
+		return nil
+	}
+
+	return pipeline.Execute(chat)
+}
+
+func (p *switchPipeline[T]) trims() bool {
+	if p.fallback == nil || !p.fallback.trims() {
+		return false
+	}
+
+	for _, c := range p.cases {
+		if !c.trims() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// viewChat is a Chat whose history, as seen by the pipelines executing on it,
+// is a transformation of the underlying history. Writes go to the underlying
+// chat.
+type viewChat struct {
+	Chat
+	view func([]Message, store.StoreRO) []Message
+}
+
+// History returns the result of view on a copy of the underlying history. The
+// underlying history is not modified.
+func (c *viewChat) History() slicev.RO[Message] {
+	history := c.Chat.History()
+	messages := make([]Message, history.Len())
+	history.CopyTo(messages)
+
+	return slicev.NewRO(c.view(messages, c.Chat.store().RO()))
+}
+
+type viewPipeline struct {
+	pipeline Pipeline
+	view     func([]Message, store.StoreRO) []Message
+}
+
+func (p *viewPipeline) Execute(chat Chat) error {
+	return p.pipeline.Execute(&viewChat{Chat: chat, view: p.view})
+}
+
+func (p *viewPipeline) trims() bool {
+	return p.pipeline.trims()
+}
+
+type viewActor struct {
+	Actor
+	view func([]Message, store.StoreRO) []Message
+}
+
+func (a *viewActor) Unwrap() Actor {
+	return a.Actor
+}
+
+func (a *viewActor) Pipeline(echo func(Message), trim bool, retryLimit int) Pipeline {
+	return &viewPipeline{
+		pipeline: a.Actor.Pipeline(echo, trim, retryLimit),
+		view:     a.view,
+	}
+}
+
+// RemapRoles returns an Actor that behaves like the given one, except that it
+// sees the chat history with roles replaced by the result of remap. Messages
+// written by the actor keep their original roles, and the stored history is
+// never modified. RemapRoles can be nested; the outer mapping is applied
+// first.
+func RemapRoles(actor Actor, remap func(Message) Role) Actor {
+	util.Assert(actor != nil, "RemapRoles nil actor")
+	util.Assert(remap != nil, "RemapRoles nil remap")
+
+	view := func(messages []Message, r store.StoreRO) []Message {
+		for i := range messages {
+			messages[i].Role = remap(messages[i])
+		}
+		return messages
+	}
+
+	return &viewActor{Actor: actor, view: view}
+}
+
+// WithWindow returns an Actor that behaves like the given one, except that it
+// sees only the last n messages of the chat history, plus the pinned messages
+// before them, e.g., to keep long conversations within the context window of
+// the model. Function messages at the start of the window are dropped as well,
+// since providers reject tool results without their tool calls, and so are
+// pinned function messages before the window; the tool calls left without
+// results are omitted by the providers. The stored history is never modified.
+func WithWindow(actor Actor, n int) Actor {
+	util.Assert(actor != nil, "WithWindow nil actor")
+	util.Assert(n > 0, "WithWindow non-positive n")
+
+	view := func(messages []Message, r store.StoreRO) []Message {
+		start := max(0, len(messages)-n)
+		for start < len(messages) && messages[start].Role == Function {
+			start++
+		}
+
+		window := make([]Message, 0, len(messages)-start)
+		for _, m := range messages[:start] {
+			if m.Pinned && m.Role != Function {
+				window = append(window, m)
+			}
+		}
+
+		return append(window, messages[start:]...)
+	}
+
+	return &viewActor{Actor: actor, view: view}
+}
+
+// WithFilter returns an Actor that behaves like the given one, except that it
+// sees only the messages of the chat history for which keep holds, e.g., only
+// user messages and its own (see Message.IsFrom), without the tool calls and
+// the output of other agents. The stored history is never modified. Dropping
+// function messages while keeping the assistant messages that requested them
+// is safe with the openai and anthropic packages, which omit tool calls
+// without results.
+func WithFilter(actor Actor, keep func(Message) bool) Actor {
+	util.Assert(actor != nil, "WithFilter nil actor")
+	util.Assert(keep != nil, "WithFilter nil keep")
+
+	view := func(messages []Message, r store.StoreRO) []Message {
+		return slices.DeleteFunc(messages, func(m Message) bool {
+			return !keep(m)
+		})
+	}
+
+	return &viewActor{Actor: actor, view: view}
+}
+
+// ContextProvider produces a piece of context for an actor, e.g., facts the
+// model cannot know. An empty result is skipped.
+type ContextProvider func(store.StoreRO) string
+
+// DateContext returns a ContextProvider that states the current date and
+// time.
+func DateContext() ContextProvider {
+	return func(store.StoreRO) string {
+		return "The current date and time is " + time.Now().Format(time.RFC1123) + "."
+	}
+}
+
+// WithContext returns an Actor that behaves like the given one, except that
+// it sees the outputs of the providers, evaluated at execution time, as user
+// messages preceding the chat history. The context is not stored in the
+// history.
+func WithContext(actor Actor, providers ...ContextProvider) Actor {
+	util.Assert(actor != nil, "WithContext nil actor")
+
+	view := func(messages []Message, r store.StoreRO) []Message {
+		prefix := make([]Message, 0, len(providers)+len(messages))
+		for _, provider := range providers {
+			if content := provider(r); content != "" {
+				prefix = append(prefix, Message{Role: User, Content: content})
+			}
+		}
+		return append(prefix, messages...)
+	}
+
+	return &viewActor{Actor: actor, view: view}
+}
+
+type converse struct {
+	left  Pipeline
+	right Pipeline
+	turns int
+}
+
+// Converse creates a Pipeline where two actors take turns talking to each
+// other. Each turn consists of a responding and then b responding. Each actor
+// sees the messages of the other one as user messages, while the underlying
+// chat history keeps the original roles.
+func Converse(a, b Actor, turns int) Pipeline {
+	util.Assert(a != nil && b != nil, "Converse nil actor")
+
+	return &converse{
+		left:  RemapRoles(a, opponentAsUser(b)).Pipeline(nil, false, 1),
+		right: RemapRoles(b, opponentAsUser(a)).Pipeline(nil, false, 1),
+		turns: turns,
+	}
+}
+
+// opponentAsUser returns a role mapping that presents the messages of the
+// given actor as user messages.
+func opponentAsUser(opponent Actor) func(Message) Role {
+	return func(message Message) Role {
+		if message.IsFrom(opponent) {
+			return User
+		}
+		return message.Role
+	}
+}
+
+func (c *converse) Execute(chat Chat) error {
+	for range c.turns {
+		if err := c.left.Execute(chat); err != nil {
+			return err
+		}
+
+		if err := c.right.Execute(chat); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *converse) trims() bool {
+	return false
+}
+
 func Get[T any](c Chat, v store.Var[T]) (T, bool) {
 	return store.Get(c.store(), v)
 }
+
+// Watch calls fn whenever v is set in the store of the chat, e.g., for
+// updating a dashboard with the progress reported by a tool, until the
+// returned function is called (see store.Watch).
+func Watch[T any](c Chat, v store.Var[T], fn func(newVal T)) func() {
+	return store.Watch(c.store(), v, fn)
+}
+
+type abortIf struct {
+	condition HistoryCondition
+	err       error
+}
+
+// AbortIf creates a Pipeline that returns err if the condition holds, and does
+// nothing otherwise. Placed inside a Chain, it acts as a guard point: a tool
+// can record a soft failure in the store, and the steps following the guard
+// are skipped. AbortIf never writes to the chat, so it does not trim.
+func AbortIf(condition Condition, err error) Pipeline {
+	util.Assert(condition != nil, "AbortIf nil condition")
+
+	return AbortIfHistory(condition.history(), err)
+}
+
+// AbortIfHistory is like AbortIf, but the condition can also inspect the chat
+// history.
+func AbortIfHistory(condition HistoryCondition, err error) Pipeline {
+	util.Assert(condition != nil, "AbortIfHistory nil condition")
+	util.Assert(err != nil, "AbortIfHistory nil err")
+
+	return &abortIf{condition: condition, err: err}
+}
+
+func (a *abortIf) Execute(chat Chat) error {
+	if a.condition.eval(chat) {
+		return a.err
+	}
+
+	return nil
+}
+
+func (a *abortIf) trims() bool {
+	return false
+}
+
+type finally struct {
+	pipeline Pipeline
+	cleanup  func() error
+}
+
+// Finally creates a Pipeline that executes the given pipeline, and then
+// cleanup, even if the pipeline fails or panics, e.g., for flushing buffered
+// echoes when a run ends. The error of the pipeline takes precedence over the
+// error of cleanup.
+func Finally(pipeline Pipeline, cleanup func() error) Pipeline {
+	util.Assert(cleanup != nil, "Finally nil cleanup")
+
+	return &finally{pipeline: pipeline, cleanup: cleanup}
+}
+
+func (f *finally) Execute(chat Chat) (err error) {
+	defer func() {
+		errCleanup := f.cleanup()
+		if err == nil {
+			err = errCleanup
+		}
+	}()
+
+	return f.pipeline.Execute(chat)
+}
+
+func (f *finally) trims() bool {
+	return f.pipeline.trims()
+}
+
+type catch struct {
+	pipeline Pipeline
+	handler  func(err error) Pipeline
+}
+
+// Catch creates a Pipeline that executes the given pipeline, and, if it
+// fails, the pipeline returned by handler for the error instead of failing,
+// e.g., one writing a canned "service unavailable" message, or an alternative
+// branch. If handler returns nil, the error is swallowed; the error of the
+// handler pipeline is returned. The messages written by the failed pipeline
+// before the error stay in the chat; wrap it in RetryWith (with a limit of 1)
+// to discard them. Within While, Catch keeps a failed iteration from ending
+// the loop.
+func Catch(pipeline Pipeline, handler func(err error) Pipeline) Pipeline {
+	util.Assert(handler != nil, "Catch nil handler")
+
+	return &catch{pipeline: pipeline, handler: handler}
+}
+
+func (c *catch) Execute(chat Chat) error {
+	err := c.pipeline.Execute(chat)
+	if err == nil {
+		return nil
+	}
+
+	util.Log.Printf("recovering from pipeline error: %v", err)
+
+	fallback := c.handler(err)
+	if fallback == nil {
+		return nil
+	}
+
+	return fallback.Execute(chat)
+}
+
+func (c *catch) trims() bool {
+	return c.pipeline.trims()
+}
+
+type reminder struct {
+	message string
+	every   int
+	drift   Condition
+}
+
+// Reminder creates a Pipeline for long loops that re-injects message, e.g.,
+// the instructions of the task, as a user message every n assistant turns, or
+// whenever drift (if not nil) holds, e.g., when a validator has flagged a
+// deviation in the store. Turns are counted in the history since the last
+// occurrence of the reminder, so Reminder is typically the first step of the
+// body of a While. The reminder is not written if the last message already is
+// the reminder.
+func Reminder(message string, n int, drift Condition) Pipeline {
+	util.Assert(n > 0, "Reminder non-positive n")
+
+	return &reminder{message: message, every: n, drift: drift}
+}
+
+func (r *reminder) isReminder(message Message) bool {
+	return message.Role == User && message.Content == r.message
+}
+
+func (r *reminder) Execute(chat Chat) error {
+	history := chat.History()
+
+	if history.Len() > 0 && r.isReminder(history.At(history.Len()-1)) {
+		return nil
+	}
+
+	turns := 0
+	for i := history.Len() - 1; i >= 0; i-- {
+		message := history.At(i)
+		if r.isReminder(message) {
+			break
+		}
+		if message.Role == Assistant {
+			turns++
+		}
+	}
+
+	due := turns >= r.every
+	if !due && r.drift != nil {
+		due = r.drift(store.View(chat.store()))
+	}
+
+	if due {
+		chat.write(Message{Role: User, Content: r.message})
+	}
+
+	return nil
+}
+
+func (r *reminder) trims() bool {
+	return false
+}
+
+type withInitialStore struct {
+	setup    func(store.Store)
+	pipeline Pipeline
+}
+
+// WithInitialStore creates a Pipeline that calls setup on the store of the
+// chat, e.g., for setting the initial values of vars, and then executes the
+// given pipeline. This makes the pipeline self-contained, so that it can be
+// reused across chats.
+func WithInitialStore(setup func(store.Store), pipeline Pipeline) Pipeline {
+	util.Assert(setup != nil, "WithInitialStore nil setup")
+
+	return &withInitialStore{setup: setup, pipeline: pipeline}
+}
+
+func (w *withInitialStore) Execute(chat Chat) error {
+	w.setup(chat.store())
+	return w.pipeline.Execute(chat)
+}
+
+func (w *withInitialStore) trims() bool {
+	return w.pipeline.trims()
+}
+
+type transform struct {
+	fn func(Message) Message
+}
+
+// Transform creates a Pipeline that replaces the last message of the history
+// with the result of fn, e.g., for stripping code fences from the response of
+// an actor before the next stage sees it. It does not add a message. If the
+// history is empty, it does nothing. As with UpdateScratchpad, transforming a
+// message written before a branch of Parallel started does not reach the
+// parent chat.
+func Transform(fn func(Message) Message) Pipeline {
+	util.Assert(fn != nil, "Transform nil fn")
+
+	return &transform{fn: fn}
+}
+
+func (t *transform) Execute(chat Chat) error {
+	history := chat.History()
+	if history.Len() == 0 {
+		return nil
+	}
+
+	// edit searches from the end, so the first candidate is the last message
+	chat.edit(func(Message) bool { return true }, t.fn(history.At(history.Len()-1)))
+
+	return nil
+}
+
+func (t *transform) trims() bool {
+	return false
+}
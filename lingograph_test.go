@@ -0,0 +1,286 @@
+package lingograph
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vasilisp/lingograph/pkg/slicev"
+	"github.com/vasilisp/lingograph/store"
+)
+
+func TestParallelFailingBranch(t *testing.T) {
+	first := NewScriptedActor([]string{"first"})
+	failing := NewScriptedActor(nil)
+	third := NewScriptedActor([]string{"third"})
+
+	pipeline := Parallel(
+		first.Pipeline(nil, false, 1),
+		failing.Pipeline(nil, false, 1),
+		third.Pipeline(nil, false, 1),
+	)
+
+	chat := NewChat()
+	done := make(chan error, 1)
+
+	go func() {
+		done <- pipeline.Execute(chat)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Parallel succeeded, want the error of the failing branch")
+		}
+		if !strings.Contains(err.Error(), "scripted actor invoked") {
+			t.Errorf("err = %v, want the error of the failing branch", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Parallel did not return")
+	}
+
+	if history := chat.History(); history.Len() != 0 {
+		t.Errorf("history has %d messages, want 0", history.Len())
+	}
+
+	for name, actor := range map[string]ScriptedActor{"first": first, "failing": failing, "third": third} {
+		if actor.Calls() != 1 {
+			t.Errorf("%s branch called %d times, want 1", name, actor.Calls())
+		}
+	}
+}
+
+func TestTimeoutCancelsActor(t *testing.T) {
+	cancelled := make(chan struct{})
+
+	blocking := NewActorContext(Assistant, func(ctx context.Context, _ slicev.RO[Message], _ store.Store, _ func(Event)) ([]Message, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	})
+
+	chat := NewChat()
+
+	err := Timeout(10*time.Millisecond, blocking.Pipeline(nil, false, 1)).Execute(chat)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("actor was not cancelled")
+	}
+
+	if history := chat.History(); history.Len() != 0 {
+		t.Errorf("history has %d messages, want 0", history.Len())
+	}
+}
+
+func TestBranchKeepsTrimming(t *testing.T) {
+	keepLast := func(messages []Message) []Message {
+		return messages[len(messages)-1:]
+	}
+
+	tests := []struct {
+		name      string
+		chat      Chat
+		unbounded bool
+		strategy  bool
+	}{
+		{name: "chat", chat: NewChat(WithUnboundedHistory()), unbounded: true},
+		{name: "recording chat", chat: NewRecordingChat(WithUnboundedHistory()), unbounded: true},
+		{name: "recording chat with strategy", chat: NewRecordingChat(WithTrimStrategy(keepLast)), strategy: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unbounded, strategy := NewTree(tt.chat).Branch().Chat().trimming()
+
+			if unbounded != tt.unbounded {
+				t.Errorf("unbounded = %v, want %v", unbounded, tt.unbounded)
+			}
+			if (strategy != nil) != tt.strategy {
+				t.Errorf("strategy set = %v, want %v", strategy != nil, tt.strategy)
+			}
+		})
+	}
+}
+
+func TestParagraphSplitterNonASCII(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		maxTokens int
+	}{
+		{name: "accented word", content: strings.Repeat("é", 50), maxTokens: 7},
+		{name: "cjk", content: strings.Repeat("日本語", 20), maxTokens: 5},
+		{name: "mixed paragraphs", content: "Ünïcödé text\n\n" + strings.Repeat("ß", 30) + "\nnaïve café", maxTokens: 4},
+		{name: "emoji", content: strings.Repeat("👍🏽", 10), maxTokens: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := ParagraphSplitter(tt.content, tt.maxTokens)
+
+			if joined := strings.Join(chunks, ""); joined != tt.content {
+				t.Fatalf("chunks join to %q, want %q", joined, tt.content)
+			}
+
+			for i, chunk := range chunks {
+				if !utf8.ValidString(chunk) {
+					t.Errorf("chunk %d %q is not valid UTF-8", i, chunk)
+				}
+				if ApproxTokens(chunk) > tt.maxTokens {
+					t.Errorf("chunk %d %q has %d tokens, want at most %d", i, chunk, ApproxTokens(chunk), tt.maxTokens)
+				}
+			}
+		})
+	}
+}
+
+// metaPipeline sets a metadata entry, and fails while fail returns true.
+type metaPipeline struct {
+	key   string
+	value func() string
+	fail  func() bool
+}
+
+func (p *metaPipeline) Execute(c Chat) error {
+	c.SetMeta(p.key, p.value())
+	if p.fail() {
+		return errors.New("attempt failed")
+	}
+	return nil
+}
+
+func (p *metaPipeline) trims() bool {
+	return false
+}
+
+func TestRetryMetadata(t *testing.T) {
+	attempt := 0
+	pipeline := &metaPipeline{
+		key: "attempt",
+		value: func() string {
+			attempt++
+			return strconv.Itoa(attempt)
+		},
+		fail: func() bool {
+			return attempt < 2
+		},
+	}
+
+	chat := NewChat()
+	if err := RetryWith(pipeline, 3, nil).Execute(chat); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := chat.Meta()["attempt"]; got != "2" {
+		t.Errorf("attempt = %q, want the metadata of the successful attempt", got)
+	}
+
+	failing := &metaPipeline{
+		key:   "failed",
+		value: func() string { return "yes" },
+		fail:  func() bool { return true },
+	}
+
+	if err := RetryWith(failing, 2, nil).Execute(chat); err == nil {
+		t.Fatal("want an error")
+	}
+
+	if got, ok := chat.Meta()["failed"]; ok {
+		t.Errorf("failed = %q, want no metadata from failed attempts", got)
+	}
+}
+
+func TestCallToolCancelled(t *testing.T) {
+	calls := 0
+	handler := func(string, store.Store) ([]Message, error) {
+		calls++
+		return nil, RetryableError(errors.New("busy"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	_, err := CallTool(ctx, handler, "{}", store.NewStore())
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+	if elapsed := time.Since(start); elapsed >= toolRetryBackoff {
+		t.Errorf("returned after %v, want no backoff", elapsed)
+	}
+}
+
+func TestTrimStrategySameLength(t *testing.T) {
+	// summarize replaces the history with a summary and the last message,
+	// keeping the length at 2
+	summarize := func(history []Message) []Message {
+		if len(history) < 2 {
+			return history
+		}
+		return []Message{{Role: User, Content: "summary"}, history[len(history)-1]}
+	}
+
+	chat := NewChat(WithTrimStrategy(summarize))
+	for _, content := range []string{"one", "two", "three"} {
+		if err := UserPrompt(content, false).Execute(chat); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	history := chat.History()
+	for i := range history.Len() {
+		got = append(got, history.At(i).Content)
+	}
+
+	if want := []string{"summary", "two", "three"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("history = %v, want %v", got, want)
+	}
+}
+
+func TestUserPromptTemplate(t *testing.T) {
+	if _, err := UserPromptTemplate("Hello {{.Var", false); err == nil {
+		t.Error("unparsable template accepted")
+	}
+
+	pipeline, err := UserPromptTemplate(`Hello {{.Var "username"}}{{if .Has "title"}}, {{.Var "title"}}{{end}}`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	username := store.PersistentVar[string]("username")
+	setup := func(r store.Store) {
+		store.Set(r, username, "Ada")
+	}
+
+	chat := NewChat()
+	if err := WithInitialStore(setup, pipeline).Execute(chat); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := chat.History().At(0).Content; got != "Hello Ada" {
+		t.Errorf("content = %q, want %q", got, "Hello Ada")
+	}
+
+	missing, err := UserPromptTemplate(`Hello {{.Var "nickname"}}`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WithInitialStore(setup, missing).Execute(chat); err == nil {
+		t.Error("missing var rendered, want an error")
+	}
+}
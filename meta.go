@@ -0,0 +1,56 @@
+package lingograph
+
+import (
+	"maps"
+	"sync"
+)
+
+// metadata holds the key-value metadata of a conversation, e.g., a user ID or
+// session tags. Unlike the store, it is not meant for pipeline logic, but for
+// bookkeeping, e.g., for filtering exported conversations.
+type metadata struct {
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+func newMetadata() *metadata {
+	return &metadata{values: make(map[string]string)}
+}
+
+func (m *metadata) set(key, value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values[key] = value
+}
+
+func (m *metadata) all() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return maps.Clone(m.values)
+}
+
+func (m *metadata) copy() *metadata {
+	return &metadata{values: m.all()}
+}
+
+// merge sets the entries of other in m, e.g., for committing the metadata of a
+// scratch chat.
+func (m *metadata) merge(other *metadata) {
+	for key, value := range other.all() {
+		m.set(key, value)
+	}
+}
+
+func (c *chat) SetMeta(key, value string) {
+	c.meta.set(key, value)
+}
+
+func (c *chat) Meta() map[string]string {
+	return c.meta.all()
+}
+
+func (c *chat) metadata() *metadata {
+	return c.meta
+}
@@ -0,0 +1,416 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/vasilisp/lingograph"
+	"github.com/vasilisp/lingograph/pkg/slicev"
+	"github.com/vasilisp/lingograph/store"
+)
+
+// DefaultBaseURL is the address of a local Ollama server.
+const DefaultBaseURL = "http://localhost:11434"
+
+func init() {
+	lingograph.RegisterMetadata[[]toolCall]("ollama.tool_calls")
+	lingograph.RegisterMetadata[toolResult]("ollama.tool_result")
+}
+
+// toolCall is a tool call of an assistant message.
+type toolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// toolResult is the ModelMetadata of function messages.
+type toolResult struct {
+	Name string `json:"name"`
+}
+
+// wire types of the chat API
+
+type apiFunctionCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type apiToolCall struct {
+	Function apiFunctionCall `json:"function"`
+}
+
+type apiMessage struct {
+	Role      string        `json:"role"`
+	Content   string        `json:"content"`
+	Images    []string      `json:"images,omitempty"`
+	ToolCalls []apiToolCall `json:"tool_calls,omitempty"`
+	ToolName  string        `json:"tool_name,omitempty"`
+}
+
+type apiFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+type apiTool struct {
+	Type     string      `json:"type"`
+	Function apiFunction `json:"function"`
+}
+
+type apiRequest struct {
+	Model    string       `json:"model"`
+	Messages []apiMessage `json:"messages"`
+	Tools    []apiTool    `json:"tools,omitempty"`
+	Stream   bool         `json:"stream"`
+}
+
+type apiResponse struct {
+	Message apiMessage `json:"message"`
+	Error   string     `json:"error"`
+}
+
+// APIError is an error response of the Ollama server.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Message is the error reported by the server.
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("ollama: %d: %s", e.StatusCode, e.Message)
+}
+
+// Retryable reports whether the request may succeed if retried: on rate
+// limiting (429) and on server errors (500-504), e.g., while the server is
+// loading the model. Client errors, e.g., a 404 for a model that is not
+// pulled, are permanent.
+func (e *APIError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusNotImplemented,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsRetryable is the default RetryPredicate of Ollama Actors. API errors are
+// retried if they are retryable (see APIError.Retryable). Errors without an
+// API response are retried only if they are transport errors (see
+// net.Error), e.g., a refused connection or a timeout. Other errors, e.g.,
+// function errors and malformed responses, are not.
+func IsRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// errNoTools is the error of models without tool support
+var errNoTools = errors.New("model does not support tools")
+
+type function struct {
+	tool lingograph.Tool
+}
+
+// FunctionSet is a set of functions that the model can call. It is
+// implemented by Actor.
+type FunctionSet interface {
+	addFunction(fn function)
+}
+
+// Actor is an Ollama-specific Actor implementation.
+type Actor interface {
+	FunctionSet
+	lingograph.Actor
+}
+
+type actor struct {
+	lingograph.Actor
+	baseURL      string
+	model        string
+	systemPrompt string
+	http         *http.Client
+	mu           sync.RWMutex
+	functions    map[string]function
+	// noTools is set once the model turns out not to support tools
+	noTools bool
+}
+
+// NewActor creates an Actor that chats with the given model of the Ollama
+// server at baseURL (e.g., DefaultBaseURL), with the given system prompt. No
+// API key is needed. Function calling is best effort: if the model does not
+// support tools, the functions of the Actor are not offered to it. Failed
+// requests are retried by its Pipelines only if they are retryable (see
+// IsRetryable).
+func NewActor(baseURL string, model string, systemPrompt string) Actor {
+	if model == "" {
+		log.Fatal("cannot create actor: empty model")
+	}
+
+	a := &actor{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		model:        model,
+		systemPrompt: systemPrompt,
+		http:         http.DefaultClient,
+		functions:    make(map[string]function),
+	}
+
+	a.Actor = lingograph.WithRetryPredicate(lingograph.NewActorContext(lingograph.Assistant, a.fn), IsRetryable)
+
+	return a
+}
+
+// toMessages converts the chat history, preceded by the system prompt (if
+// not empty), to chat API messages.
+func toMessages(systemPrompt string, history slicev.RO[lingograph.Message]) ([]apiMessage, error) {
+	messages := make([]apiMessage, 0, 1+history.Len())
+
+	if systemPrompt != "" {
+		messages = append(messages, apiMessage{Role: "system", Content: systemPrompt})
+	}
+
+	for i := range history.Len() {
+		msg := history.At(i)
+
+		message := apiMessage{Content: msg.Content}
+
+		switch msg.Role {
+		case lingograph.Assistant:
+			message.Role = "assistant"
+			calls, _ := msg.ModelMetadata.([]toolCall)
+			for _, call := range calls {
+				message.ToolCalls = append(message.ToolCalls, apiToolCall{Function: apiFunctionCall(call)})
+			}
+		case lingograph.Function:
+			message.Role = "tool"
+			if result, ok := msg.ModelMetadata.(toolResult); ok {
+				message.ToolName = result.Name
+			}
+		case lingograph.Developer, lingograph.System:
+			message.Role = "system"
+		default:
+			message.Role = "user"
+		}
+
+		for _, part := range msg.Parts {
+			switch part.Kind {
+			case lingograph.PartText:
+			case lingograph.PartImage:
+				_, data, ok := strings.Cut(part.URL, ";base64,")
+				if !strings.HasPrefix(part.URL, "data:") || !ok {
+					return nil, fmt.Errorf("only data URLs are supported for images")
+				}
+				message.Images = append(message.Images, data)
+			default:
+				return nil, fmt.Errorf("unsupported content part kind %d", part.Kind)
+			}
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// Unwrap returns the underlying lingograph.Actor, whose identity the Actor
+// shares.
+func (a *actor) Unwrap() lingograph.Actor {
+	return a.Actor
+}
+
+func (a *actor) post(ctx context.Context, body apiRequest) (*apiMessage, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+"/api/chat", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := a.http.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response apiResponse
+	if err := json.Unmarshal(data, &response); err != nil && httpResp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("cannot parse response: %w", err)
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		message := response.Error
+		if message == "" {
+			message = string(data)
+		}
+
+		apiErr := &APIError{StatusCode: httpResp.StatusCode, Message: message}
+		if strings.Contains(message, "does not support tools") {
+			return nil, fmt.Errorf("%w: %w", errNoTools, apiErr)
+		}
+		return nil, apiErr
+	}
+
+	return &response.Message, nil
+}
+
+// call executes a tool call, and returns the function messages and the other
+// messages (e.g., images) of the results. Errors of the function are handled
+// according to their disposition (see lingograph.ToolError).
+func (a *actor) call(ctx context.Context, functions map[string]function, call toolCall, r store.Store, emit func(lingograph.Event)) ([]lingograph.Message, []lingograph.Message, error) {
+	fn, ok := functions[call.Name]
+	if !ok {
+		return nil, nil, fmt.Errorf("function %s not found", call.Name)
+	}
+
+	emit(lingograph.Event{Kind: lingograph.EventToolStart, Tool: call.Name})
+	messages, err := lingograph.CallTool(ctx, fn.tool.Handler, string(call.Arguments), r)
+	emit(lingograph.Event{Kind: lingograph.EventToolEnd, Tool: call.Name, Err: err})
+
+	if err != nil {
+		if lingograph.DispositionOf(err) != lingograph.ToolRecoverable {
+			return nil, nil, fmt.Errorf("error calling function %s: %w", call.Name, err)
+		}
+
+		message := lingograph.Message{Role: lingograph.Function, Content: "Error: " + err.Error(), ModelMetadata: toolResult{Name: call.Name}}
+		return []lingograph.Message{message}, nil, nil
+	}
+
+	functionMessages := make([]lingograph.Message, 0, len(messages))
+	otherMessages := make([]lingograph.Message, 0)
+
+	for _, msg := range messages {
+		if msg.Role != lingograph.Function {
+			otherMessages = append(otherMessages, msg)
+			continue
+		}
+
+		msg.Content = fn.tool.Format.Fence(msg.Content)
+		msg.ModelMetadata = toolResult{Name: call.Name}
+		functionMessages = append(functionMessages, msg)
+	}
+
+	return functionMessages, otherMessages, nil
+}
+
+func (a *actor) fn(ctx context.Context, history slicev.RO[lingograph.Message], r store.Store, emit func(lingograph.Event)) ([]lingograph.Message, error) {
+	messages, err := toMessages(a.systemPrompt, history)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.RLock()
+	functions := make(map[string]function, len(a.functions))
+	for name, fn := range a.functions {
+		functions[name] = fn
+	}
+	noTools := a.noTools
+	a.mu.RUnlock()
+
+	body := apiRequest{Model: a.model, Messages: messages}
+
+	if !noTools {
+		for _, name := range slices.Sorted(maps.Keys(functions)) {
+			fn := functions[name]
+			body.Tools = append(body.Tools, apiTool{
+				Type: "function",
+				Function: apiFunction{
+					Name:        fn.tool.Name,
+					Description: fn.tool.Description,
+					Parameters:  fn.tool.Schema,
+				},
+			})
+		}
+	}
+
+	response, err := a.post(ctx, body)
+	if errors.Is(err, errNoTools) {
+		a.mu.Lock()
+		a.noTools = true
+		a.mu.Unlock()
+
+		body.Tools = nil
+		response, err = a.post(ctx, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	assistant := lingograph.Message{Role: lingograph.Assistant, Content: response.Content}
+
+	var calls []toolCall
+	for _, call := range response.ToolCalls {
+		calls = append(calls, toolCall(call.Function))
+	}
+	if len(calls) > 0 {
+		assistant.ModelMetadata = calls
+	}
+
+	functionMessages := make([]lingograph.Message, 0)
+	// non-function messages (e.g., images) follow all the function messages
+	trailingMessages := make([]lingograph.Message, 0)
+
+	for _, call := range calls {
+		results, others, err := a.call(ctx, functions, call, r, emit)
+		if err != nil {
+			return nil, err
+		}
+
+		functionMessages = append(functionMessages, results...)
+		trailingMessages = append(trailingMessages, others...)
+	}
+
+	result := make([]lingograph.Message, 0, 1+len(functionMessages)+len(trailingMessages))
+	result = append(result, assistant)
+	result = append(result, functionMessages...)
+	result = append(result, trailingMessages...)
+
+	return result, nil
+}
+
+func (a *actor) addFunction(fn function) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.functions[fn.tool.Name] = fn
+}
+
+// AddTool adds a provider-neutral Tool to the Actor, so that the model can
+// call it, if it supports tools.
+func AddTool(a FunctionSet, tool lingograph.Tool) {
+	a.addFunction(function{tool: tool})
+}
+
+// AddFunction adds a function to the Actor that can be called by the model,
+// as with openai.AddFunction.
+func AddFunction[I any, O any](a FunctionSet, name string, description string, fn func(I, store.Store) (O, error), options ...lingograph.DecodeOption) {
+	AddTool(a, lingograph.NewTool(name, description, fn, options...))
+}
@@ -0,0 +1,99 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/vasilisp/lingograph"
+	"github.com/vasilisp/lingograph/internal/util"
+	"github.com/vasilisp/lingograph/pkg/slicev"
+	"github.com/vasilisp/lingograph/store"
+)
+
+// transcriptionFormats are the audio formats accepted by the transcription
+// endpoint.
+var transcriptionFormats = []string{"flac", "mp3", "mp4", "mpeg", "mpga", "m4a", "ogg", "wav", "webm"}
+
+func (client *client) transcribe(ctx context.Context, path string) (string, error) {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if !slices.Contains(transcriptionFormats, format) {
+		return "", permanent(fmt.Errorf("unsupported audio format %q", format))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", permanent(err)
+	}
+	defer file.Close()
+
+	transcription, err := client.client.Audio.Transcriptions.New(ctx, openai.AudioTranscriptionNewParams{
+		File:  file,
+		Model: openai.AudioModelWhisper1,
+	})
+	if err != nil {
+		return "", wrapError(err)
+	}
+
+	return transcription.Text, nil
+}
+
+// Transcriber returns an Actor that transcribes the audio file at the given
+// path, and records the transcript as a user message. The file is read anew on
+// every invocation, so it can be overwritten between turns, e.g., by a voice
+// recorder. Transcription errors are subject to the retry limit of the
+// pipeline, if they are retryable (see IsRetryable); an unsupported format or
+// a missing file fails the pipeline immediately. A cancelled chat context
+// (see lingograph.WithChatContext) stops an upload in progress.
+func Transcriber(client Client, path string) lingograph.Actor {
+	actor := lingograph.NewActorContext(lingograph.User, func(ctx context.Context, history slicev.RO[lingograph.Message], r store.Store, emit func(lingograph.Event)) ([]lingograph.Message, error) {
+		text, err := client.transcribe(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+
+		return []lingograph.Message{{Role: lingograph.User, Content: text}}, nil
+	})
+
+	return lingograph.WithRetryPredicate(actor, IsRetryable)
+}
+
+func (client *client) speak(ctx context.Context, text string, voice string, format string, w io.Writer) error {
+	response, err := client.client.Audio.Speech.New(ctx, openai.AudioSpeechNewParams{
+		Input:          text,
+		Model:          openai.SpeechModelGPT4oMiniTTS,
+		Voice:          openai.AudioSpeechNewParamsVoice(voice),
+		ResponseFormat: openai.AudioSpeechNewParamsResponseFormat(format),
+	})
+	if err != nil {
+		return wrapError(err)
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(w, response.Body)
+	return err
+}
+
+// Speaker returns a function that converts the content of messages to speech,
+// and writes the audio to w. The returned function can be used as an "echo"
+// callback in pipelines, like extra.Echoln. The voice (e.g., "alloy") and the
+// audio format (e.g., "mp3" or "wav") are passed to the OpenAI speech
+// endpoint. Errors are logged, since echo callbacks cannot fail. Echo
+// callbacks have no context, so speech is not interrupted by the chat
+// context.
+func Speaker(client Client, voice string, format string, w io.Writer) func(msg lingograph.Message) {
+	return func(msg lingograph.Message) {
+		if msg.Content == "" {
+			return
+		}
+
+		if err := client.speak(context.Background(), msg.Content, voice, format, w); err != nil {
+			util.Log.Printf("error generating speech: %v", err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package openai
+
+import (
+	"sync"
+
+	"github.com/vasilisp/lingograph/store"
+)
+
+// TokenBudget is the store variable holding the remaining number of tokens
+// for a run. If it is set, every completion subtracts the total tokens it
+// used, and no further completions are requested once the budget is
+// exhausted: actors then write no messages instead of failing, so loops stop
+// gracefully (see WithinBudget). The budget is part of checkpoints.
+var TokenBudget = store.PersistentVar[int64]("openai.token_budget")
+
+// budgetMu serializes budget updates of concurrent actors, e.g., within
+// lingograph.Parallel.
+var budgetMu sync.Mutex
+
+// WithinBudget is a lingograph.Condition that holds unless TokenBudget is set
+// and exhausted.
+func WithinBudget(r store.StoreRO) bool {
+	budgetMu.Lock()
+	defer budgetMu.Unlock()
+
+	budget, ok := store.GetRO(r, TokenBudget)
+	return !ok || budget > 0
+}
+
+func spendTokens(r store.Store, tokens int64) {
+	budgetMu.Lock()
+	defer budgetMu.Unlock()
+
+	budget, ok := store.Get(r, TokenBudget)
+	if !ok {
+		return
+	}
+
+	store.Set(r, TokenBudget, budget-tokens)
+}
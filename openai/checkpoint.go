@@ -0,0 +1,44 @@
+package openai
+
+import (
+	"encoding/json"
+
+	"github.com/vasilisp/lingograph"
+)
+
+func init() {
+	lingograph.RegisterMetadata[[]functionCallMetadata]("openai.tool_calls")
+	lingograph.RegisterMetadata[functionCallID]("openai.tool_call_id")
+	lingograph.RegisterMetadata[refusal]("openai.refusal")
+}
+
+type functionCallMetadataJSON struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Arguments   string `json:"arguments"`
+	NrResponses int    `json:"nr_responses"`
+	Deferred    bool   `json:"deferred,omitempty"`
+}
+
+func (m functionCallMetadata) MarshalJSON() ([]byte, error) {
+	return json.Marshal(functionCallMetadataJSON{
+		ID:          m.param.ID,
+		Name:        m.param.Function.Name,
+		Arguments:   m.param.Function.Arguments,
+		NrResponses: m.nrResponses,
+		Deferred:    m.deferred,
+	})
+}
+
+func (m *functionCallMetadata) UnmarshalJSON(data []byte) error {
+	var v functionCallMetadataJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	m.param = ToolCall{ID: v.ID, Name: v.Name, Arguments: v.Arguments}.param()
+	m.nrResponses = v.NrResponses
+	m.deferred = v.Deferred
+
+	return nil
+}
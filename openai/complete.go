@@ -0,0 +1,45 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/vasilisp/lingograph"
+	"github.com/vasilisp/lingograph/pkg/slicev"
+	"github.com/vasilisp/lingograph/store"
+)
+
+// Complete runs a single completion with the given prompts, and returns the
+// response as a value of type O, without a chat or a pipeline. The response is
+// constrained to the JSON schema of O.
+func Complete[O any](client Client, model Model, systemPrompt string, userPrompt string) (O, error) {
+	var value O
+
+	req := request{
+		model:          model,
+		systemPrompts:  []string{systemPrompt},
+		responseFormat: structuredFormat[O]("response"),
+	}
+
+	history := slicev.NewRO([]lingograph.Message{{Role: lingograph.User, Content: userPrompt}})
+
+	response, err := client.ask(context.Background(), req, history, store.NewStore(), func(lingograph.Event) {})
+	if err != nil {
+		return value, err
+	}
+
+	if len(response) == 0 {
+		return value, fmt.Errorf("empty response")
+	}
+
+	if IsRefusal(response[0]) {
+		return value, fmt.Errorf("completion refused: %s", response[0].Content)
+	}
+
+	if err := json.Unmarshal([]byte(response[0].Content), &value); err != nil {
+		return value, fmt.Errorf("cannot parse response: %w", err)
+	}
+
+	return value, nil
+}
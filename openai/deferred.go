@@ -0,0 +1,156 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/openai/openai-go"
+
+	"github.com/vasilisp/lingograph"
+	"github.com/vasilisp/lingograph/internal/util"
+	"github.com/vasilisp/lingograph/pkg/slicev"
+	"github.com/vasilisp/lingograph/store"
+)
+
+// RejectedToolCall is the function result of tool calls that are not approved
+// for execution (see ExecuteToolCalls).
+const RejectedToolCall = "The tool call was rejected by the user."
+
+// ToolCall is a tool call requested by the model.
+type ToolCall struct {
+	// ID identifies the tool call within the conversation.
+	ID string
+	// Name is the name of the function to call.
+	Name string
+	// Arguments are the arguments of the call, as JSON.
+	Arguments string
+}
+
+// PendingToolCalls returns the deferred tool calls (see
+// Actor.PipelineDeferred) of the last assistant message of the history that
+// have not been executed yet.
+func PendingToolCalls(history slicev.RO[lingograph.Message]) []ToolCall {
+	for i := history.Len() - 1; i >= 0; i-- {
+		msg := history.At(i)
+		if msg.Role != lingograph.Assistant {
+			continue
+		}
+
+		metadata, _ := msg.ModelMetadata.([]functionCallMetadata)
+
+		toolCalls := make([]ToolCall, 0, len(metadata))
+		for _, toolCall := range metadata {
+			if !toolCall.deferred || toolCall.responses(history, i) > 0 {
+				continue
+			}
+
+			toolCalls = append(toolCalls, ToolCall{
+				ID:        toolCall.param.ID,
+				Name:      toolCall.param.Function.Name,
+				Arguments: toolCall.param.Function.Arguments,
+			})
+		}
+
+		return toolCalls
+	}
+
+	return nil
+}
+
+// ExecuteToolCalls creates a Pipeline that executes the pending tool calls
+// (see PendingToolCalls) with the functions of the Actor, and writes the
+// results to the chat. Tool calls for which approve returns false are not
+// executed, and get RejectedToolCall as their result. If approve is nil, all
+// tool calls are executed.
+func ExecuteToolCalls(a Actor, approve func(ToolCall) bool) lingograph.Pipeline {
+	return executeToolCalls(a, func(toolCalls []ToolCall) []bool {
+		approved := make([]bool, len(toolCalls))
+		for i, toolCall := range toolCalls {
+			approved[i] = approve == nil || approve(toolCall)
+		}
+		return approved
+	})
+}
+
+// Approve creates a Pipeline that surfaces the pending tool calls (see
+// PendingToolCalls) to prompt, e.g., a CLI y/n question, and executes them
+// only if prompt returns true. Otherwise, every tool call gets
+// RejectedToolCall as its result, so that the model can react to the
+// rejection. Approve does nothing if there are no pending tool calls. In an
+// agentic loop, it follows a Pipeline of the Actor with deferred tool calls:
+//
+//	AgentLoop(Chain(a.PipelineDeferred(nil, false, 1), Approve(a, prompt)), nil, 10)
+func Approve(a Actor, prompt func([]ToolCall) bool) lingograph.Pipeline {
+	util.Assert(prompt != nil, "Approve nil prompt")
+
+	return executeToolCalls(a, func(toolCalls []ToolCall) []bool {
+		approved := make([]bool, len(toolCalls))
+		if prompt(toolCalls) {
+			for i := range approved {
+				approved[i] = true
+			}
+		}
+		return approved
+	})
+}
+
+// executeToolCalls is like ExecuteToolCalls, except that approve decides on
+// all the pending tool calls at once.
+func executeToolCalls(a Actor, approve func([]ToolCall) []bool) lingograph.Pipeline {
+	fn := func(ctx context.Context, history slicev.RO[lingograph.Message], r store.Store, emit func(lingograph.Event)) ([]lingograph.Message, error) {
+		toolCalls := PendingToolCalls(history)
+		if len(toolCalls) == 0 {
+			return nil, nil
+		}
+
+		approved := approve(toolCalls)
+		req := a.toolRequest()
+
+		functionMessages := make([]lingograph.Message, 0, len(toolCalls))
+		// as in ask, non-function messages follow all the function messages
+		trailingMessages := make([]lingograph.Message, 0)
+
+		for i, toolCall := range toolCalls {
+			if !approved[i] {
+				functionMessages = append(functionMessages, lingograph.Message{
+					Role:          lingograph.Function,
+					Content:       RejectedToolCall,
+					ModelMetadata: functionCallID{ID: fmt.Sprintf("%s_0", toolCall.ID)},
+				})
+				continue
+			}
+
+			results, others, err := req.execute(ctx, toolCall.param(), r, emit)
+			if err != nil {
+				return nil, err
+			}
+
+			// a tool call without results would stay pending
+			if len(results) == 0 {
+				results = append(results, lingograph.Message{
+					Role:          lingograph.Function,
+					Content:       "",
+					ModelMetadata: functionCallID{ID: fmt.Sprintf("%s_0", toolCall.ID)},
+				})
+			}
+
+			functionMessages = append(functionMessages, results...)
+			trailingMessages = append(trailingMessages, others...)
+		}
+
+		return append(functionMessages, trailingMessages...), nil
+	}
+
+	return lingograph.NewActorVariant(a, lingograph.Function, fn).Pipeline(nil, false, 1)
+}
+
+func (toolCall ToolCall) param() openai.ChatCompletionMessageToolCallParam {
+	return openai.ChatCompletionMessageToolCallParam{
+		ID:   toolCall.ID,
+		Type: "function",
+		Function: openai.ChatCompletionMessageToolCallFunctionParam{
+			Name:      toolCall.Name,
+			Arguments: toolCall.Arguments,
+		},
+	}
+}
@@ -0,0 +1,119 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/openai/openai-go"
+	"github.com/vasilisp/lingograph"
+	"github.com/vasilisp/lingograph/internal/util"
+	"github.com/vasilisp/lingograph/pkg/slicev"
+)
+
+// checkToolCalls verifies that every tool call in an assistant message is
+// answered by a function message, and that every function message answers a
+// preceding tool call.
+func checkToolCalls(history slicev.RO[lingograph.Message]) error {
+	pending := make(map[string]struct{})
+
+	for index := range history.Len() {
+		msg := history.At(index)
+
+		if msg.Role != lingograph.Function && len(pending) > 0 {
+			return fmt.Errorf("%d tool calls without response", len(pending))
+		}
+
+		switch msg.Role {
+		case lingograph.Assistant:
+			toolCalls, ok := msg.ModelMetadata.([]functionCallMetadata)
+			if !ok {
+				continue
+			}
+			for _, toolCall := range toolCalls {
+				for i := range toolCall.responses(history, index) {
+					pending[fmt.Sprintf("%s_%d", toolCall.param.ID, i)] = struct{}{}
+				}
+			}
+		case lingograph.Function:
+			toolCallID, ok := msg.ModelMetadata.(functionCallID)
+			if !ok {
+				return fmt.Errorf("function message without tool call ID")
+			}
+			if _, ok := pending[toolCallID.ID]; !ok {
+				return fmt.Errorf("function message for unknown tool call %s", toolCallID.ID)
+			}
+			delete(pending, toolCallID.ID)
+		}
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf("%d tool calls without response", len(pending))
+	}
+
+	return nil
+}
+
+type fineTuningExample struct {
+	Messages []openai.ChatCompletionMessageParamUnion `json:"messages"`
+	Metadata map[string]string                        `json:"metadata,omitempty"`
+}
+
+type exportConfig struct {
+	metadata bool
+}
+
+// ExportOption configures ExportJSONL.
+type ExportOption func(*exportConfig)
+
+// ExportMetadata makes ExportJSONL write the metadata of each chat (see
+// Chat.SetMeta), if any, in a "metadata" field next to the messages, e.g.,
+// for filtering the examples before uploading them. The field is not part of
+// the fine-tuning format, so it has to be removed before uploading.
+func ExportMetadata() ExportOption {
+	return func(c *exportConfig) {
+		c.metadata = true
+	}
+}
+
+// ExportJSONL writes the chats to w in the OpenAI fine-tuning format, one
+// {"messages": [...]} object per line. Empty conversations are skipped, and so
+// are conversations with orphaned tool calls or tool results, with a warning.
+func ExportJSONL(chats []lingograph.Chat, w io.Writer, options ...ExportOption) error {
+	var config exportConfig
+	for _, option := range options {
+		option(&config)
+	}
+
+	encoder := json.NewEncoder(w)
+
+	for i, chat := range chats {
+		history := chat.History()
+		if history.Len() == 0 {
+			continue
+		}
+
+		if err := checkToolCalls(history); err != nil {
+			util.Log.Printf("skipping conversation %d: %v", i, err)
+			continue
+		}
+
+		messages, err := ToParams(history, "")
+		if err != nil {
+			util.Log.Printf("skipping conversation %d: %v", i, err)
+			continue
+		}
+
+		example := fineTuningExample{Messages: messages}
+		if config.metadata {
+			example.Metadata = chat.Meta()
+		}
+
+		err = encoder.Encode(example)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
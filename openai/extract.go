@@ -0,0 +1,135 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/openai/openai-go/packages/param"
+	"github.com/openai/openai-go/shared"
+	"github.com/vasilisp/lingograph"
+	"github.com/vasilisp/lingograph/internal/schema"
+	"github.com/vasilisp/lingograph/pkg/slicev"
+	"github.com/vasilisp/lingograph/store"
+)
+
+const (
+	extractPrompt = "Extract the information in the last message into a JSON object following the schema."
+	repairPrompt  = "The extracted object is invalid: %v. Respond with a corrected JSON object."
+)
+
+// structuredFormat returns a response format constraining the response to the
+// JSON schema of T.
+func structuredFormat[T any](name string) *shared.ResponseFormatJSONSchemaJSONSchemaParam {
+	return &shared.ResponseFormatJSONSchemaJSONSchemaParam{
+		Name:   name,
+		Schema: schema.Reflect[T](),
+		Strict: param.NewOpt(false),
+	}
+}
+
+// Extract creates a Pipeline that asks the model of the Actor to extract the
+// information in the last message of the history into a value of type T, and
+// stores the value in out. The response is constrained to the JSON schema of
+// T. The Pipeline does not write to the chat history; downstream steps can
+// read the value with store.GetRO.
+func Extract[T any](a Actor, out store.Var[T]) lingograph.Pipeline {
+	return ValidatedExtract(a, out, nil, 0)
+}
+
+// generateValid asks generate for a JSON value of type T, parses the response,
+// and checks the value with validate (if not nil). If the response is invalid,
+// the model is asked again, with the response and repairPrompt (formatted with
+// the error) appended to messages, while attempts is positive; every request
+// decrements attempts, and at least one request is made. A refusal is
+// returned as is, without a value. If the token budget is exhausted (see
+// TokenBudget), no response is returned, and no error. The error of the last
+// invalid response is wrapped in errStructured.
+func generateValid[T any](
+	ctx context.Context,
+	generate func(context.Context, slicev.RO[lingograph.Message], store.Store, func(lingograph.Event)) ([]lingograph.Message, error),
+	messages []lingograph.Message,
+	r store.Store,
+	emit func(lingograph.Event),
+	validate func(T) error,
+	attempts *int,
+	repairPrompt string,
+) ([]lingograph.Message, T, error) {
+	var value T
+	var err error
+
+	for first := true; first || *attempts > 0; first = false {
+		*attempts--
+
+		var response []lingograph.Message
+		response, err = generate(ctx, slicev.NewRO(messages), r, emit)
+		if err != nil {
+			return nil, value, err
+		}
+
+		if len(response) == 0 {
+			if !WithinBudget(r.RO()) {
+				return nil, value, nil
+			}
+			return nil, value, fmt.Errorf("empty structured response")
+		}
+
+		if IsRefusal(response[0]) {
+			return response[:1], value, nil
+		}
+
+		err = json.Unmarshal([]byte(response[0].Content), &value)
+		if err != nil {
+			err = fmt.Errorf("cannot parse response: %w", err)
+		} else if validate != nil {
+			err = validate(value)
+		}
+
+		if err == nil {
+			return response[:1], value, nil
+		}
+
+		messages = append(messages,
+			lingograph.Message{Role: lingograph.Assistant, Content: response[0].Content},
+			lingograph.Message{Role: lingograph.User, Content: fmt.Sprintf(repairPrompt, err)},
+		)
+	}
+
+	var zero T
+	return nil, zero, fmt.Errorf("%w: %w", errStructured, err)
+}
+
+// ValidatedExtract creates a Pipeline like Extract, which also checks the
+// extracted value with validate (if not nil). If the value is invalid, or the
+// response cannot be parsed, the model is asked to repair it, with the error
+// as feedback, up to maxRepairs times before the Pipeline fails. Only a valid
+// value is stored in out; nothing is stored once the token budget is exhausted
+// (see TokenBudget).
+func ValidatedExtract[T any](a Actor, out store.Var[T], validate func(T) error, maxRepairs int) lingograph.Pipeline {
+	format := structuredFormat[T]("extraction")
+
+	generate := a.fn(func(req *request) {
+		req.responseFormat = format
+	})
+
+	fn := func(ctx context.Context, history slicev.RO[lingograph.Message], r store.Store, emit func(lingograph.Event)) ([]lingograph.Message, error) {
+		messages := make([]lingograph.Message, history.Len(), history.Len()+1)
+		history.CopyTo(messages)
+		messages = append(messages, lingograph.Message{Role: lingograph.User, Content: extractPrompt})
+
+		attempts := maxRepairs + 1
+		response, value, err := generateValid(ctx, generate, messages, r, emit, validate, &attempts, repairPrompt)
+		if err != nil || len(response) == 0 {
+			return nil, err
+		}
+
+		if IsRefusal(response[0]) {
+			return nil, fmt.Errorf("extraction refused: %s", response[0].Content)
+		}
+
+		store.Set(r, out, value)
+		return nil, nil
+	}
+
+	return lingograph.NewActorVariant(a, lingograph.Assistant, fn).Pipeline(nil, false, 1)
+}
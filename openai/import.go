@@ -0,0 +1,266 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/vasilisp/lingograph"
+)
+
+type importedToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type importedMessage struct {
+	Role       string             `json:"role"`
+	Content    json.RawMessage    `json:"content"`
+	Refusal    string             `json:"refusal"`
+	ToolCalls  []importedToolCall `json:"tool_calls"`
+	ToolCallID string             `json:"tool_call_id"`
+}
+
+// content returns the message content, which is either a string or an array
+// of content parts. Parts are returned only if there are non-text parts, i.e.,
+// images or audio.
+func (m *importedMessage) content() (string, []lingograph.ContentPart, error) {
+	if len(m.Content) == 0 || string(m.Content) == "null" {
+		return "", nil, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(m.Content, &s); err == nil {
+		return s, nil, nil
+	}
+
+	var imported []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		ImageURL struct {
+			URL string `json:"url"`
+		} `json:"image_url"`
+		InputAudio struct {
+			Data   string `json:"data"`
+			Format string `json:"format"`
+		} `json:"input_audio"`
+	}
+	if err := json.Unmarshal(m.Content, &imported); err != nil {
+		return "", nil, fmt.Errorf("invalid content: %w", err)
+	}
+
+	parts := make([]lingograph.ContentPart, 0, len(imported))
+	multimodal := false
+
+	for _, part := range imported {
+		switch part.Type {
+		case "text":
+			parts = append(parts, lingograph.TextPart(part.Text))
+		case "image_url":
+			parts = append(parts, lingograph.ImagePart(part.ImageURL.URL))
+			multimodal = true
+		case "input_audio":
+			data, err := base64.StdEncoding.DecodeString(part.InputAudio.Data)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid audio data: %w", err)
+			}
+			parts = append(parts, lingograph.AudioPart(data, part.InputAudio.Format))
+			multimodal = true
+		}
+	}
+
+	message := lingograph.NewMessageParts(lingograph.User, parts...)
+	if !multimodal {
+		return message.Content, nil, nil
+	}
+
+	return message.Content, parts, nil
+}
+
+// splitCallID splits a tool call ID expanded by toMessages, e.g., "call_x_1",
+// into the ID of the tool call and the index of the response.
+func splitCallID(id string) (string, int, bool) {
+	i := strings.LastIndexByte(id, '_')
+	if i <= 0 {
+		return "", 0, false
+	}
+
+	n, err := strconv.Atoi(id[i+1:])
+	if err != nil || n < 0 || strconv.Itoa(n) != id[i+1:] {
+		return "", 0, false
+	}
+
+	return id[:i], n, true
+}
+
+// importToolCalls converts the tool calls of an assistant message, and records
+// the IDs of their function messages in resultIDs. Tool calls that were
+// expanded by toMessages, i.e., "<id>_0" to "<id>_<n-1>" with the same
+// function, are merged back into a tool call with n responses, so that
+// exporting and importing a conversation again is stable.
+func importToolCalls(imported []importedToolCall, resultIDs map[string]string) []functionCallMetadata {
+	type group struct {
+		first   importedToolCall
+		indices []int
+	}
+
+	groups := make(map[string]*group)
+	for _, toolCall := range imported {
+		base, n, ok := splitCallID(toolCall.ID)
+		if !ok {
+			continue
+		}
+
+		g, ok := groups[base]
+		if !ok {
+			g = &group{first: toolCall}
+			groups[base] = g
+		}
+		if toolCall.Function == g.first.Function {
+			g.indices = append(g.indices, n)
+		} else {
+			g.indices = append(g.indices, -1)
+		}
+	}
+
+	// expanded reports whether the tool calls with the given base are a
+	// complete expansion
+	expanded := func(base string) bool {
+		g, ok := groups[base]
+		if !ok {
+			return false
+		}
+		for i, n := range g.indices {
+			if n != i {
+				return false
+			}
+		}
+		return true
+	}
+
+	toolCalls := make([]functionCallMetadata, 0, len(imported))
+
+	for _, toolCall := range imported {
+		base, _, ok := splitCallID(toolCall.ID)
+		if ok && expanded(base) {
+			resultIDs[toolCall.ID] = toolCall.ID
+
+			g := groups[base]
+			if toolCall.ID != g.first.ID {
+				continue
+			}
+
+			toolCall.ID = base
+			toolCalls = append(toolCalls, importedCall(toolCall, len(g.indices)))
+			continue
+		}
+
+		resultIDs[toolCall.ID] = toolCall.ID + "_0"
+		toolCalls = append(toolCalls, importedCall(toolCall, 1))
+	}
+
+	return toolCalls
+}
+
+func importedCall(toolCall importedToolCall, nrResponses int) functionCallMetadata {
+	return functionCallMetadata{
+		param: openai.ChatCompletionMessageToolCallParam{
+			ID: toolCall.ID,
+			Function: openai.ChatCompletionMessageToolCallFunctionParam{
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			},
+		},
+		nrResponses: nrResponses,
+	}
+}
+
+// ImportMessages parses a conversation in the OpenAI messages format, either a
+// JSON array of messages or an object with a "messages" field, and converts it
+// to lingograph messages. Tool calls and tool results are converted with the
+// metadata needed by the Actor to send them back to OpenAI. System and
+// developer messages become lingograph.System and lingograph.Developer
+// messages, which the Actor sends after its own system prompts.
+func ImportMessages(r io.Reader) ([]lingograph.Message, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var imported []importedMessage
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var example struct {
+			Messages []importedMessage `json:"messages"`
+		}
+		if err := json.Unmarshal(data, &example); err != nil {
+			return nil, err
+		}
+		imported = example.Messages
+	} else if err := json.Unmarshal(data, &imported); err != nil {
+		return nil, err
+	}
+
+	messages := make([]lingograph.Message, 0, len(imported))
+	// resultIDs maps the IDs of the imported tool calls to the IDs of their
+	// function messages
+	resultIDs := make(map[string]string)
+
+	for i, msg := range imported {
+		content, parts, err := msg.content()
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+
+		switch msg.Role {
+		case "system":
+			messages = append(messages, lingograph.Message{Role: lingograph.System, Content: content})
+		case "developer":
+			messages = append(messages, lingograph.Message{Role: lingograph.Developer, Content: content})
+		case "user":
+			messages = append(messages, lingograph.Message{Role: lingograph.User, Content: content, Parts: parts})
+		case "assistant":
+			message := lingograph.Message{Role: lingograph.Assistant, Content: content}
+
+			if msg.Refusal != "" && content == "" {
+				message.Content = msg.Refusal
+				message.ModelMetadata = refusal{}
+			}
+
+			if len(msg.ToolCalls) > 0 {
+				message.ModelMetadata = importToolCalls(msg.ToolCalls, resultIDs)
+			}
+
+			messages = append(messages, message)
+		case "tool":
+			if msg.ToolCallID == "" {
+				return nil, fmt.Errorf("message %d: tool message without tool_call_id", i)
+			}
+			// has to match the expansion in toMessages()
+			id, ok := resultIDs[msg.ToolCallID]
+			if !ok {
+				id = msg.ToolCallID + "_0"
+			}
+
+			messages = append(messages, lingograph.Message{
+				Role:          lingograph.Function,
+				Content:       content,
+				ModelMetadata: functionCallID{ID: id},
+			})
+		default:
+			return nil, fmt.Errorf("message %d: unknown role %q", i, msg.Role)
+		}
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,612 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/option"
+	"github.com/vasilisp/lingograph"
+	"github.com/vasilisp/lingograph/pkg/slicev"
+	"github.com/vasilisp/lingograph/store"
+)
+
+// fakeServer is a Chat Completions endpoint that replies with the given
+// completions in order, repeating the last one, and records the request
+// bodies. Streaming requests get the content of the completion as a stream of
+// word deltas.
+type fakeServer struct {
+	*httptest.Server
+	mu          sync.Mutex
+	completions []string
+	requests    []map[string]any
+}
+
+func newFakeServer(t *testing.T, completions ...string) *fakeServer {
+	t.Helper()
+
+	s := &fakeServer{completions: completions}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *fakeServer) serve(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	i := min(len(s.requests), len(s.completions)-1)
+	s.requests = append(s.requests, decoded)
+	s.mu.Unlock()
+
+	if decoded["stream"] == true {
+		streamCompletion(w, s.completions[i])
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, s.completions[i])
+}
+
+// streamCompletion writes the content of the first choice of the completion
+// as server-sent chunks, one per word.
+func streamCompletion(w http.ResponseWriter, completion string) {
+	var decoded struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal([]byte(completion), &decoded); err != nil || len(decoded.Choices) == 0 {
+		http.Error(w, "cannot stream completion", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+
+	chunk := func(delta string, finish string) {
+		finishReason := "null"
+		if finish != "" {
+			finishReason = strconv.Quote(finish)
+		}
+
+		content, _ := json.Marshal(delta)
+		fmt.Fprintf(w, `data: {"id": "chatcmpl-test", "object": "chat.completion.chunk", "created": 0, "model": "gpt-4o-mini", "choices": [{"index": 0, "delta": {"role": "assistant", "content": %s}, "finish_reason": %s}]}`+"\n\n", content, finishReason)
+	}
+
+	for _, word := range strings.SplitAfter(decoded.Choices[0].Message.Content, " ") {
+		chunk(word, "")
+	}
+	chunk("", "stop")
+
+	io.WriteString(w, `data: {"id": "chatcmpl-test", "object": "chat.completion.chunk", "created": 0, "model": "gpt-4o-mini", "choices": [], "usage": {"prompt_tokens": 1, "completion_tokens": 2, "total_tokens": 3}}`+"\n\n")
+	io.WriteString(w, "data: [DONE]\n\n")
+}
+
+// request returns the body of the i-th request.
+func (s *fakeServer) request(t *testing.T, i int) map[string]any {
+	t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i >= len(s.requests) {
+		t.Fatalf("request %d not made; %d requests", i, len(s.requests))
+	}
+
+	return s.requests[i]
+}
+
+func (s *fakeServer) client() Client {
+	return NewClientWithOptions("test", option.WithBaseURL(s.URL), option.WithMaxRetries(0))
+}
+
+// completion returns a chat completion with a single choice holding the given
+// message.
+func completion(message string) string {
+	return fmt.Sprintf(`{
+		"id": "chatcmpl-test",
+		"object": "chat.completion",
+		"created": 0,
+		"model": "gpt-4o-mini",
+		"choices": [{"index": 0, "finish_reason": "stop", "message": %s}],
+		"usage": {"prompt_tokens": 1, "completion_tokens": 1, "total_tokens": 2}
+	}`, message)
+}
+
+func float(f float64) *float64 {
+	return &f
+}
+
+func TestPipelineWithTemperature(t *testing.T) {
+	tests := []struct {
+		name        string
+		temperature *float64
+		override    *float64
+		want        any
+	}{
+		{name: "default", temperature: float(0.5), want: 0.5},
+		{name: "override", temperature: float(0.5), override: float(1), want: 1.0},
+		{name: "override without default", override: float(0), want: 0.0},
+		{name: "neither", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, completion(`{"role": "assistant", "content": "ok"}`))
+			actor := NewActor(server.client(), GPT4oMini, "", tt.temperature)
+
+			pipeline := actor.Pipeline(nil, false, 1)
+			if tt.override != nil {
+				pipeline = actor.PipelineWithTemperature(*tt.override, nil, false, 1)
+			}
+
+			if err := pipeline.Execute(lingograph.NewChat()); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := server.request(t, 0)["temperature"]; got != tt.want {
+				t.Errorf("temperature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefusal(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		content string
+		refusal bool
+	}{
+		{name: "content", message: `{"role": "assistant", "content": "Paris"}`, content: "Paris"},
+		{name: "refusal", message: `{"role": "assistant", "content": null, "refusal": "I cannot help with that."}`, content: "I cannot help with that.", refusal: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, completion(tt.message))
+			actor := NewActor(server.client(), GPT4oMini, "", nil)
+
+			chat := lingograph.NewChat()
+			if err := actor.Pipeline(nil, false, 1).Execute(chat); err != nil {
+				t.Fatal(err)
+			}
+
+			history := chat.History()
+			if history.Len() != 1 {
+				t.Fatalf("history has %d messages, want 1", history.Len())
+			}
+
+			message := history.At(0)
+			if message.Content != tt.content {
+				t.Errorf("content = %q, want %q", message.Content, tt.content)
+			}
+			if IsRefusal(message) != tt.refusal {
+				t.Errorf("IsRefusal = %v, want %v", IsRefusal(message), tt.refusal)
+			}
+
+			// the refusal is sent back as such
+			messages, err := toMessages(request{}, history)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assistant := messages[0].OfAssistant
+			if assistant == nil {
+				t.Fatal("not an assistant message")
+			}
+			if got := assistant.Refusal.Valid(); got != tt.refusal {
+				t.Errorf("refusal param set = %v, want %v", got, tt.refusal)
+			}
+		})
+	}
+}
+
+func TestUserAndMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     string
+		metadata map[string]string
+	}{
+		{name: "unset"},
+		{name: "user", user: "user-42"},
+		{name: "metadata", metadata: map[string]string{"tenant": "acme"}},
+		{name: "both", user: "user-42", metadata: map[string]string{"tenant": "acme", "session": "s1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, completion(`{"role": "assistant", "content": "ok"}`))
+			actor := NewActor(server.client(), GPT4oMini, "", nil)
+			actor.SetUser(tt.user)
+			actor.SetMetadata(tt.metadata)
+
+			if err := actor.Pipeline(nil, false, 1).Execute(lingograph.NewChat()); err != nil {
+				t.Fatal(err)
+			}
+
+			body := server.request(t, 0)
+
+			user, ok := body["user"]
+			if ok != (tt.user != "") || (ok && user != tt.user) {
+				t.Errorf("user = %v (present %v), want %q", user, ok, tt.user)
+			}
+
+			metadata, ok := body["metadata"].(map[string]any)
+			if ok != (len(tt.metadata) > 0) {
+				t.Fatalf("metadata present = %v, want %v", ok, len(tt.metadata) > 0)
+			}
+			if len(metadata) != len(tt.metadata) {
+				t.Errorf("metadata = %v, want %v", metadata, tt.metadata)
+			}
+			for key, value := range tt.metadata {
+				if metadata[key] != value {
+					t.Errorf("metadata[%s] = %v, want %q", key, metadata[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestDeveloperRole(t *testing.T) {
+	history := []lingograph.Message{
+		{Role: lingograph.System, Content: "Be brief."},
+		{Role: lingograph.Developer, Content: "Use metric units."},
+		{Role: lingograph.User, Content: "How far is it?"},
+	}
+
+	tests := []struct {
+		name      string
+		developer bool
+		// want holds, for every message, whether it is a developer message
+		// (true) or a system message (false); user messages are skipped
+		want []bool
+	}{
+		{name: "system role", developer: false, want: []bool{false, false, true}},
+		{name: "developer role", developer: true, want: []bool{true, true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request{systemPrompts: []string{"You are a guide."}, developer: tt.developer}
+
+			messages, err := toMessages(req, slicev.NewRO(history))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(messages) != 4 {
+				t.Fatalf("got %d messages, want 4", len(messages))
+			}
+
+			for i, developer := range tt.want {
+				message := messages[i]
+				if developer && message.OfDeveloper == nil {
+					t.Errorf("message %d is not a developer message", i)
+				}
+				if !developer && message.OfSystem == nil {
+					t.Errorf("message %d is not a system message", i)
+				}
+			}
+
+			if messages[3].OfUser == nil {
+				t.Error("message 3 is not a user message")
+			}
+		})
+	}
+}
+
+func TestPipelineStreamCallbacks(t *testing.T) {
+	const content = "Hello streaming world"
+
+	tests := []struct {
+		name    string
+		echo    bool
+		onToken bool
+	}{
+		{name: "no callbacks"},
+		{name: "echo only", echo: true},
+		{name: "deltas only", onToken: true},
+		{name: "echo and deltas", echo: true, onToken: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, completion(`{"role": "assistant", "content": "`+content+`"}`))
+			actor := NewActor(server.client(), GPT4oMini, "", nil)
+
+			var echoed []lingograph.Message
+			var echo func(lingograph.Message)
+			if tt.echo {
+				echo = func(message lingograph.Message) {
+					echoed = append(echoed, message)
+				}
+			}
+
+			var deltas []string
+			var onToken func(string)
+			if tt.onToken {
+				onToken = func(delta string) {
+					deltas = append(deltas, delta)
+				}
+			}
+
+			chat := lingograph.NewChat()
+			if err := actor.PipelineStream(onToken, echo, false, 1).Execute(chat); err != nil {
+				t.Fatal(err)
+			}
+
+			history := chat.History()
+			if history.Len() != 1 || history.At(0).Content != content {
+				t.Fatalf("history = %v, want the final message", history)
+			}
+
+			if stream, _ := server.request(t, 0)["stream"].(bool); stream != tt.onToken {
+				t.Errorf("stream = %v, want %v", stream, tt.onToken)
+			}
+
+			if tt.echo && (len(echoed) != 1 || echoed[0].Content != content) {
+				t.Errorf("echoed = %v, want the final message once", echoed)
+			}
+
+			if tt.onToken {
+				if len(deltas) < 2 || strings.Join(deltas, "") != content {
+					t.Errorf("deltas = %q, want the content in pieces", deltas)
+				}
+			}
+		})
+	}
+}
+
+func TestToolOrder(t *testing.T) {
+	names := []string{"weather", "alarm", "search", "calendar", "music"}
+
+	server := newFakeServer(t, completion(`{"role": "assistant", "content": "ok"}`))
+	actor := NewActor(server.client(), GPT4oMini, "", nil)
+
+	type args struct{}
+	for _, name := range names {
+		AddFunction(actor, name, "", func(args, store.Store) (string, error) {
+			return "", nil
+		})
+	}
+
+	for i := range 5 {
+		if err := actor.Pipeline(nil, false, 1).Execute(lingograph.NewChat()); err != nil {
+			t.Fatal(err)
+		}
+
+		tools, _ := server.request(t, i)["tools"].([]any)
+		var got []string
+		for _, tool := range tools {
+			function, _ := tool.(map[string]any)["function"].(map[string]any)
+			name, _ := function["name"].(string)
+			got = append(got, name)
+		}
+
+		if want := []string{"alarm", "calendar", "music", "search", "weather"}; !slices.Equal(got, want) {
+			t.Fatalf("tools of request %d = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestImportMessagesRoles(t *testing.T) {
+	input := `[
+		{"role": "system", "content": "Be brief."},
+		{"role": "developer", "content": "Use metric units."},
+		{"role": "user", "content": "How far is it?"},
+		{"role": "assistant", "content": "5 km."}
+	]`
+
+	messages, err := ImportMessages(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []lingograph.Role{lingograph.System, lingograph.Developer, lingograph.User, lingograph.Assistant}
+	if len(messages) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(messages), len(want))
+	}
+
+	for i, role := range want {
+		if messages[i].Role != role {
+			t.Errorf("message %d has role %v, want %v", i, messages[i].Role, role)
+		}
+	}
+}
+
+func TestImportExportRoundTrip(t *testing.T) {
+	input := `[
+		{"role": "user", "content": "Weather in Paris and Rome?"},
+		{"role": "assistant", "content": null, "tool_calls": [
+			{"id": "call_a", "type": "function", "function": {"name": "weather", "arguments": "{\"city\": \"Paris\"}"}},
+			{"id": "call_b_7", "type": "function", "function": {"name": "weather", "arguments": "{\"city\": \"Rome\"}"}}
+		]},
+		{"role": "tool", "tool_call_id": "call_a", "content": "sunny"},
+		{"role": "tool", "tool_call_id": "call_b_7", "content": "rainy"},
+		{"role": "assistant", "content": "Sunny in Paris, rainy in Rome."}
+	]`
+
+	// roundTrip imports the conversation, and exports it in the messages format
+	roundTrip := func(input string) string {
+		t.Helper()
+
+		messages, err := ImportMessages(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		params, err := toMessages(request{}, slicev.NewRO(messages))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		output, err := json.Marshal(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(output)
+	}
+
+	first := roundTrip(input)
+	if second := roundTrip(first); second != first {
+		t.Errorf("second round trip changed the conversation:\n%s\nwant\n%s", second, first)
+	}
+
+	for _, id := range []string{`"call_a_0"`, `"call_b_7_0"`} {
+		if strings.Count(first, id) != 2 {
+			t.Errorf("want the tool call and the result with ID %s in %s", id, first)
+		}
+	}
+}
+
+func TestTranscriberInvalidInput(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "unsupported format", path: "notes.txt"},
+		{name: "missing file", path: "missing.mp3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, completion(`{"role": "assistant", "content": "ok"}`))
+			actor := Transcriber(server.client(), filepath.Join(t.TempDir(), tt.path))
+
+			start := time.Now()
+			if err := actor.Pipeline(nil, false, 3).Execute(lingograph.NewChat()); err == nil {
+				t.Fatal("transcription succeeded, want an error")
+			}
+
+			// a retry would wait for at least a second
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("failed after %v, want no retries", elapsed)
+			}
+		})
+	}
+}
+
+func TestStructuredSpentBudget(t *testing.T) {
+	type answer struct {
+		City string `json:"city"`
+	}
+
+	spend := func(r store.Store) {
+		store.Set(r, TokenBudget, 0)
+	}
+
+	tests := []struct {
+		name     string
+		pipeline func(Client, store.Var[answer]) lingograph.Pipeline
+	}{
+		{name: "structured actor", pipeline: func(client Client, out store.Var[answer]) lingograph.Pipeline {
+			return NewStructuredActor(client, GPT4oMini, "", nil, out, nil).Pipeline(nil, false, 3)
+		}},
+		{name: "extract", pipeline: func(client Client, out store.Var[answer]) lingograph.Pipeline {
+			return ValidatedExtract(NewActor(client, GPT4oMini, "", nil), out, nil, 2)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, completion(`{"role": "assistant", "content": "{\"city\": \"Paris\"}"}`))
+			out := store.FreshVar[answer]()
+
+			chat := lingograph.NewChat()
+			if err := lingograph.WithInitialStore(spend, tt.pipeline(server.client(), out)).Execute(chat); err != nil {
+				t.Fatalf("err = %v, want none on a spent budget", err)
+			}
+
+			if history := chat.History(); history.Len() != 0 {
+				t.Errorf("history has %d messages, want 0", history.Len())
+			}
+
+			if _, ok := lingograph.Get(chat, out); ok {
+				t.Error("value stored on a spent budget")
+			}
+
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			if len(server.requests) != 0 {
+				t.Errorf("%d requests made, want 0", len(server.requests))
+			}
+		})
+	}
+}
+
+func TestStructuredRetryLimit(t *testing.T) {
+	type answer struct {
+		City string `json:"city"`
+	}
+
+	server := newFakeServer(t, completion(`{"role": "assistant", "content": "not json"}`))
+	actor := NewStructuredActor(server.client(), GPT4oMini, "", nil, store.FreshVar[answer](), nil)
+	pipeline := actor.Pipeline(nil, false, 3)
+
+	for execution := range 2 {
+		if err := pipeline.Execute(lingograph.NewChat()); err == nil {
+			t.Fatal("invalid responses accepted")
+		}
+
+		server.mu.Lock()
+		requests := len(server.requests)
+		server.mu.Unlock()
+
+		if want := 3 * (execution + 1); requests != want {
+			t.Errorf("%d requests after execution %d, want %d", requests, execution, want)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	refused := &url.Error{Op: "Post", URL: "http://localhost", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "transport error", err: refused, want: true},
+		{name: "wrapped transport error", err: fmt.Errorf("request: %w", refused), want: true},
+		{name: "rate limited", err: &APIError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "invalid request", err: &APIError{StatusCode: http.StatusBadRequest}, want: false},
+		{name: "invalid input", err: permanent(refused), want: false},
+		{name: "function error", err: errors.New("no such city"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package openai
+
+import (
+	"maps"
+	"sync"
+)
+
+// FunctionSet is a set of functions that the model can call. It is
+// implemented by Actor and FunctionRegistry.
+type FunctionSet interface {
+	addFunction(fn function)
+}
+
+// FunctionRegistry is a set of functions that can be shared by multiple
+// Actors (see Actor.UseFunctions), so that the schema of each function is
+// reflected only once.
+type FunctionRegistry interface {
+	FunctionSet
+	copyTo(dst map[string]function)
+}
+
+type functionRegistry struct {
+	mu        sync.RWMutex
+	functions map[string]function
+}
+
+// NewFunctionRegistry creates an empty FunctionRegistry. Functions are added
+// to it with AddFunction and AddFunctionUnsafe.
+func NewFunctionRegistry() FunctionRegistry {
+	return &functionRegistry{functions: make(map[string]function)}
+}
+
+func (r *functionRegistry) addFunction(fn function) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.functions[fn.name] = fn
+}
+
+func (r *functionRegistry) copyTo(dst map[string]function) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	maps.Copy(dst, r.functions)
+}
@@ -0,0 +1,42 @@
+package openai
+
+import (
+	"fmt"
+
+	"github.com/vasilisp/lingograph"
+	"github.com/vasilisp/lingograph/pkg/slicev"
+	"github.com/vasilisp/lingograph/store"
+)
+
+// calledTool reports whether the last assistant message of the history
+// requested a call of the named tool.
+func calledTool(history slicev.RO[lingograph.Message], name string) bool {
+	for i := history.Len() - 1; i >= 0; i-- {
+		msg := history.At(i)
+		if msg.Role != lingograph.Assistant {
+			continue
+		}
+
+		toolCalls, _ := msg.ModelMetadata.([]functionCallMetadata)
+		for _, toolCall := range toolCalls {
+			if toolCall.param.Function.Name == name {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return false
+}
+
+// RequireToolCall creates a Pipeline that fails unless the last assistant
+// message of the history requested a call of the named tool, e.g., for
+// validating agent behavior in tests or guarded flows.
+func RequireToolCall(name string) lingograph.Pipeline {
+	condition := func(history slicev.RO[lingograph.Message], r store.StoreRO) bool {
+		return !calledTool(history, name)
+	}
+
+	return lingograph.AbortIfHistory(condition, fmt.Errorf("tool %s was not called", name))
+}
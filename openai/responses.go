@@ -0,0 +1,223 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/param"
+	"github.com/openai/openai-go/responses"
+	"github.com/vasilisp/lingograph"
+	"github.com/vasilisp/lingograph/pkg/slicev"
+	"github.com/vasilisp/lingograph/store"
+)
+
+// NewResponsesActor creates an Actor like NewActor, except that it uses the
+// OpenAI Responses API instead of Chat Completions. Functions, deferred tool
+// calls and the other features of Actor work the same way. The history is
+// sent with every request, so no state is stored on the OpenAI side.
+// It will exit if the chat model is invalid; see NewResponsesActorE.
+func NewResponsesActor(client Client, chatModel Model, systemPrompt string, temperature *float64) Actor {
+	a, err := NewResponsesActorE(client, chatModel, systemPrompt, temperature)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return a
+}
+
+// NewResponsesActorE creates an Actor like NewResponsesActor, but returns an
+// error instead of exiting if the chat model is invalid.
+func NewResponsesActorE(client Client, chatModel Model, systemPrompt string, temperature *float64) (Actor, error) {
+	a, err := NewActorE(client, chatModel, systemPrompt, temperature)
+	if err != nil {
+		return nil, err
+	}
+
+	a.(*actor).request.responsesAPI = true
+	return a, nil
+}
+
+// toResponsesInput converts the chat history, preceded by an instruction
+// message for each non-empty system prompt (see toMessages), to Responses API
+// input items.
+func toResponsesInput(req request, history slicev.RO[lingograph.Message]) (responses.ResponseInputParam, error) {
+	input := make(responses.ResponseInputParam, 0, len(req.systemPrompts)+history.Len())
+
+	role := responses.EasyInputMessageRoleSystem
+	if req.developer {
+		role = responses.EasyInputMessageRoleDeveloper
+	}
+
+	consumedBefore := lastAssistant(history)
+
+	for _, systemPrompt := range req.systemPrompts {
+		if systemPrompt != "" {
+			input = append(input, responses.ResponseInputItemParamOfMessage(systemPrompt, role))
+		}
+	}
+
+	for index := range history.Len() {
+		msg := history.At(index)
+		switch msg.Role {
+		case lingograph.Assistant:
+			if msg.Content != "" {
+				input = append(input, responses.ResponseInputItemParamOfMessage(msg.Content, responses.EasyInputMessageRoleAssistant))
+			}
+
+			toolCalls, _ := msg.ModelMetadata.([]functionCallMetadata)
+			for _, toolCall := range toolCalls {
+				for i := range toolCall.responses(history, index) {
+					// has to match the expansion in call()
+					id := fmt.Sprintf("%s_%d", toolCall.param.ID, i)
+					input = append(input, responses.ResponseInputItemParamOfFunctionCall(toolCall.param.Function.Arguments, id, toolCall.param.Function.Name))
+				}
+			}
+		case lingograph.Function:
+			toolCallID, ok := msg.ModelMetadata.(functionCallID)
+			if !ok {
+				return nil, fmt.Errorf("function message without tool call ID")
+			}
+			input = append(input, responses.ResponseInputItemParamOfFunctionCallOutput(toolCallID.ID, req.resultContent(msg, index < consumedBefore)))
+		case lingograph.Developer:
+			input = append(input, responses.ResponseInputItemParamOfMessage(msg.Content, responses.EasyInputMessageRoleDeveloper))
+		case lingograph.System:
+			input = append(input, responses.ResponseInputItemParamOfMessage(msg.Content, role))
+		default:
+			if len(msg.Parts) == 0 {
+				input = append(input, responses.ResponseInputItemParamOfMessage(msg.Content, responses.EasyInputMessageRoleUser))
+				continue
+			}
+
+			parts := make(responses.ResponseInputMessageContentListParam, 0, len(msg.Parts))
+			for _, part := range msg.Parts {
+				switch part.Kind {
+				case lingograph.PartText:
+					parts = append(parts, responses.ResponseInputContentParamOfInputText(part.Text))
+				case lingograph.PartImage:
+					image := responses.ResponseInputContentParamOfInputImage(responses.ResponseInputImageDetailAuto)
+					image.OfInputImage.ImageURL = param.NewOpt(part.URL)
+					parts = append(parts, image)
+				default:
+					return nil, fmt.Errorf("content part kind %d not supported by the Responses API", part.Kind)
+				}
+			}
+			input = append(input, responses.ResponseInputItemParamOfMessage(parts, responses.EasyInputMessageRoleUser))
+		}
+	}
+
+	return input, nil
+}
+
+func (client *client) askResponses(ctx context.Context, req request, history slicev.RO[lingograph.Message], r store.Store, emit func(lingograph.Event)) ([]lingograph.Message, error) {
+	if !WithinBudget(r.RO()) {
+		return nil, nil
+	}
+
+	model, err := req.model.ToOpenAI()
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := toResponsesInput(req, history)
+	if err != nil {
+		return nil, err
+	}
+
+	params := responses.ResponseNewParams{
+		Model: model,
+		Input: responses.ResponseNewParamsInputUnion{OfInputItemList: input},
+		Store: param.NewOpt(false),
+	}
+
+	if req.responseFormat == nil {
+		for _, name := range slices.Sorted(maps.Keys(req.functions)) {
+			fn := req.functions[name]
+			tool := responses.ToolParamOfFunction(fn.def.Name, fn.def.Parameters, false)
+			tool.OfFunction.Description = fn.def.Description
+			params.Tools = append(params.Tools, tool)
+		}
+	} else {
+		schema, ok := req.responseFormat.Schema.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid response schema of type %T", req.responseFormat.Schema)
+		}
+
+		format := responses.ResponseFormatTextConfigParamOfJSONSchema(req.responseFormat.Name, schema)
+		format.OfJSONSchema.Strict = req.responseFormat.Strict
+		params.Text = responses.ResponseTextConfigParam{Format: format}
+	}
+
+	if req.temperature != nil {
+		params.Temperature = param.NewOpt(*req.temperature)
+	}
+
+	if req.params.MaxTokens != nil {
+		params.MaxOutputTokens = param.NewOpt(int64(*req.params.MaxTokens))
+	}
+
+	if req.params.TopP != nil {
+		params.TopP = param.NewOpt(*req.params.TopP)
+	}
+
+	if req.user != "" {
+		params.User = param.NewOpt(req.user)
+	}
+
+	if len(req.metadata) > 0 {
+		params.Metadata = req.metadata
+	}
+
+	response, err := client.client.Responses.New(ctx, params)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	spendTokens(r, response.Usage.TotalTokens)
+	recordUsage(r, string(response.Model), Usage{
+		PromptTokens:     response.Usage.InputTokens,
+		CompletionTokens: response.Usage.OutputTokens,
+		TotalTokens:      response.Usage.TotalTokens,
+		Calls:            1,
+	})
+
+	if len(response.Output) == 0 {
+		return nil, errors.New("no output in response")
+	}
+
+	var content strings.Builder
+	var refused strings.Builder
+	toolCalls := make([]openai.ChatCompletionMessageToolCallParam, 0)
+
+	for _, item := range response.Output {
+		switch item.Type {
+		case "message":
+			for _, part := range item.Content {
+				switch part.Type {
+				case "output_text":
+					content.WriteString(part.Text)
+				case "refusal":
+					refused.WriteString(part.Refusal)
+				}
+			}
+		case "function_call":
+			toolCalls = append(toolCalls, ToolCall{ID: item.CallID, Name: item.Name, Arguments: item.Arguments}.param())
+		}
+	}
+
+	if refused.Len() > 0 && content.Len() == 0 && len(toolCalls) == 0 {
+		return []lingograph.Message{{Role: lingograph.Assistant, Content: refused.String(), ModelMetadata: refusal{}}}, nil
+	}
+
+	// the Responses API is not streamed; pass the whole content at once
+	if req.onToken != nil && content.Len() > 0 {
+		req.onToken(content.String())
+	}
+
+	return req.respond(ctx, content.String(), toolCalls, r, emit)
+}
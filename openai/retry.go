@@ -0,0 +1,131 @@
+package openai
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// APIError is an error response of the OpenAI API. The errors of Actors (and
+// of the audio functions) that come from API responses are APIErrors; errors
+// without an API response, e.g., network errors, are returned unchanged.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response, e.g., 429.
+	StatusCode int
+	// Type is the type of the error reported by the API, e.g.,
+	// "invalid_request_error".
+	Type string
+	// Code is the code of the error reported by the API, if any, e.g.,
+	// "context_length_exceeded".
+	Code string
+	err  *openai.Error
+}
+
+func (e *APIError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error of the OpenAI SDK.
+func (e *APIError) Unwrap() error {
+	return e.err
+}
+
+// Retryable reports whether the request may succeed if retried: on rate
+// limiting (429) and on server errors (500-504). Other errors, e.g., invalid
+// requests (400), authentication errors (401) and unknown models (404), are
+// permanent.
+func (e *APIError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusNotImplemented,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RetryAfter returns the delay before retrying that the API suggests with the
+// Retry-After header (in seconds, or as an HTTP date) or the retry-after-ms
+// header, or 0 if there is none. It makes APIError a
+// lingograph.RetryAfterError, so the Pipelines of Actors honor the delay.
+func (e *APIError) RetryAfter() time.Duration {
+	if e.err.Response == nil {
+		return 0
+	}
+	header := e.err.Response.Header
+
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return max(0, time.Duration(seconds*float64(time.Second)))
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(date))
+	}
+
+	return 0
+}
+
+// wrapError wraps the errors of API responses in APIErrors.
+func wrapError(err error) error {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return &APIError{StatusCode: apiErr.StatusCode, Type: apiErr.Type, Code: apiErr.Code, err: apiErr}
+	}
+	return err
+}
+
+// permanentError marks errors that cannot be fixed by retrying, e.g., a
+// missing input file.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// permanent wraps err so that IsRetryable rejects it.
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsRetryable is the default RetryPredicate of OpenAI Actors. API errors are
+// retried if they are retryable (see APIError.Retryable), so that permanent
+// failures do not waste the retry budget. Errors without an API response are
+// retried only if they are transport errors (see net.Error), e.g., a refused
+// connection or a timeout. Invalid inputs, e.g., a missing audio file, and
+// other errors, e.g., function errors, are not retried.
+func IsRetryable(err error) bool {
+	var permanentErr *permanentError
+	if errors.As(err, &permanentErr) {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
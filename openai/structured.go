@@ -0,0 +1,133 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/vasilisp/lingograph"
+	"github.com/vasilisp/lingograph/pkg/slicev"
+	"github.com/vasilisp/lingograph/store"
+)
+
+const structuredRepairPrompt = "Your response is invalid: %v. Respond again with a corrected JSON object."
+
+// errStructured marks invalid structured responses, which consume an attempt
+// of the Pipeline without being retried from scratch
+var errStructured = errors.New("invalid structured response")
+
+type structuredActor struct {
+	*actor
+	// generate returns the message generation function for the given
+	// request override, which decrements attempts for every request; it is
+	// bound to O at construction, since methods cannot have type parameters
+	generate func(override func(*request), attempts *int) func(context.Context, slicev.RO[lingograph.Message], store.Store, func(lingograph.Event)) ([]lingograph.Message, error)
+}
+
+// NewStructuredActor creates an Actor like NewActor, whose responses are
+// constrained to the JSON schema of O (see ToOpenAISchema); functions are
+// not offered to the model. The Pipelines of the Actor parse each response
+// into a value of type O, check it with validate (if not nil), and store the
+// valid value in out, in addition to writing the response to the chat. If the
+// response is invalid, the model is asked again with the error as a hint; each
+// such attempt counts against the retry limit of the Pipeline, as do the
+// retries of failed requests, so that a Pipeline makes at most as many
+// requests as its retry limit. Once the token
+// budget is exhausted (see TokenBudget), the Pipelines write no messages, as
+// with NewActor. Since the
+// response is only useful whole, PipelineStream does not stream, and
+// PipelineDeferred behaves like Pipeline.
+// It will exit if the chat model is invalid; see NewStructuredActorE.
+func NewStructuredActor[O any](client Client, chatModel Model, systemPrompt string, temperature *float64, out store.Var[O], validate func(O) error) Actor {
+	a, err := NewStructuredActorE(client, chatModel, systemPrompt, temperature, out, validate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return a
+}
+
+// NewStructuredActorE creates an Actor like NewStructuredActor, but returns an
+// error instead of exiting if the chat model is invalid.
+func NewStructuredActorE[O any](client Client, chatModel Model, systemPrompt string, temperature *float64, out store.Var[O], validate func(O) error) (Actor, error) {
+	base, err := NewActorE(client, chatModel, systemPrompt, temperature)
+	if err != nil {
+		return nil, err
+	}
+
+	a := base.(*actor)
+	a.request.responseFormat = structuredFormat[O]("response")
+
+	generate := func(override func(*request), attempts *int) func(context.Context, slicev.RO[lingograph.Message], store.Store, func(lingograph.Event)) ([]lingograph.Message, error) {
+		generate := a.fn(override)
+
+		return func(ctx context.Context, history slicev.RO[lingograph.Message], r store.Store, emit func(lingograph.Event)) ([]lingograph.Message, error) {
+			messages := make([]lingograph.Message, history.Len())
+			history.CopyTo(messages)
+
+			response, value, err := generateValid(ctx, generate, messages, r, emit, validate, attempts, structuredRepairPrompt)
+			if err != nil || len(response) == 0 {
+				return nil, err
+			}
+
+			if !IsRefusal(response[0]) {
+				store.Set(r, out, value)
+			}
+
+			return response, nil
+		}
+	}
+
+	return &structuredActor{actor: a, generate: generate}, nil
+}
+
+// structuredPipeline builds a new Pipeline for every execution, so that every
+// execution has a retry limit of its own.
+type structuredPipeline struct {
+	lingograph.Pipeline
+	build func() lingograph.Pipeline
+}
+
+func (p *structuredPipeline) Execute(chat lingograph.Chat) error {
+	return p.build().Execute(chat)
+}
+
+// pipeline creates a Pipeline whose requests, both the repairs of invalid
+// responses and the retries of failed requests, count against retryLimit.
+func (a *structuredActor) pipeline(override func(*request), echo func(lingograph.Message), trim bool, retryLimit int) lingograph.Pipeline {
+	retry := a.retry
+
+	build := func() lingograph.Pipeline {
+		attempts := max(1, retryLimit)
+		fn := a.generate(override, &attempts)
+
+		variant := lingograph.WithRetryPredicate(lingograph.NewActorVariant(a.Actor, lingograph.Assistant, fn), func(err error) bool {
+			return attempts > 0 && !errors.Is(err, errStructured) && (retry == nil || retry(err))
+		})
+
+		return variant.Pipeline(echo, trim, retryLimit)
+	}
+
+	return &structuredPipeline{Pipeline: build(), build: build}
+}
+
+func (a *structuredActor) Pipeline(echo func(lingograph.Message), trim bool, retryLimit int) lingograph.Pipeline {
+	return a.pipeline(nil, echo, trim, retryLimit)
+}
+
+func (a *structuredActor) PipelineWithTemperature(temperature float64, echo func(lingograph.Message), trim bool, retryLimit int) lingograph.Pipeline {
+	return a.pipeline(func(req *request) {
+		req.temperature = &temperature
+	}, echo, trim, retryLimit)
+}
+
+// PipelineDeferred is Pipeline, since no functions are offered to the model.
+func (a *structuredActor) PipelineDeferred(echo func(lingograph.Message), trim bool, retryLimit int) lingograph.Pipeline {
+	return a.Pipeline(echo, trim, retryLimit)
+}
+
+// PipelineStream is Pipeline; onToken is not called, since partial JSON is of
+// no use.
+func (a *structuredActor) PipelineStream(onToken func(string), echo func(lingograph.Message), trim bool, retryLimit int) lingograph.Pipeline {
+	return a.Pipeline(echo, trim, retryLimit)
+}
@@ -0,0 +1,62 @@
+package openai
+
+import (
+	"encoding/json"
+
+	"github.com/vasilisp/lingograph"
+)
+
+const (
+	// tokensPerMessage is the overhead of the message framing
+	tokensPerMessage = 3
+	// tokensPerReply primes the reply of the assistant
+	tokensPerReply = 3
+	// tokensPerImage is the cost of a 1024x1024 image in high detail
+	tokensPerImage = 765
+)
+
+// ApproxPromptTokens approximates the number of prompt tokens of the request
+// the Actor would send for the chat: the system prompts, the history and the
+// schemas of the functions. It is a heuristic, not a tokenizer: text is
+// estimated with lingograph.ApproxTokens, which can be off by a wide margin,
+// and images count as 765 tokens each. It is good enough for deciding when to
+// trim or switch models, with a safety margin.
+func (a *actor) ApproxPromptTokens(chat lingograph.Chat) (int, error) {
+	req := a.toolRequest()
+
+	n := tokensPerReply
+
+	for _, systemPrompt := range req.systemPrompts {
+		if systemPrompt != "" {
+			n += tokensPerMessage + lingograph.ApproxTokens(systemPrompt)
+		}
+	}
+
+	history := chat.History()
+	for i := range history.Len() {
+		msg := history.At(i)
+		n += tokensPerMessage + lingograph.ApproxTokens(msg.Content)
+
+		for _, part := range msg.Parts {
+			if part.Kind == lingograph.PartImage {
+				n += tokensPerImage
+			}
+		}
+
+		if toolCalls, ok := msg.ModelMetadata.([]functionCallMetadata); ok {
+			for _, toolCall := range toolCalls {
+				n += lingograph.ApproxTokens(toolCall.param.Function.Name + toolCall.param.Function.Arguments)
+			}
+		}
+	}
+
+	for _, fn := range req.functions {
+		def, err := json.Marshal(fn.def)
+		if err != nil {
+			return 0, err
+		}
+		n += lingograph.ApproxTokens(string(def))
+	}
+
+	return n, nil
+}
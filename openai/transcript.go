@@ -0,0 +1,75 @@
+package openai
+
+import (
+	"strings"
+
+	"github.com/vasilisp/lingograph"
+	"github.com/vasilisp/lingograph/pkg/slicev"
+)
+
+// Step is a step of an agentic run: the text of an assistant message and,
+// if the model requested a tool call, the call and its results.
+type Step struct {
+	// Text is the content of the assistant message, e.g., the reasoning
+	// before a tool call, or the final answer. For assistant messages with
+	// multiple tool calls, only the first Step holds the text.
+	Text string
+	// ToolCall is the requested tool call, or nil.
+	ToolCall *ToolCall
+	// Results are the function results for the tool call. Results is empty
+	// for deferred tool calls that have not been executed yet.
+	Results []string
+}
+
+// Transcript reconstructs the steps of an agentic run from the history, by
+// matching the tool calls of assistant messages with the function messages
+// answering them. User messages are not part of the transcript.
+func Transcript(history slicev.RO[lingograph.Message]) []Step {
+	steps := make([]Step, 0)
+	// index of the step of each tool call ID
+	pending := make(map[string]int)
+
+	for i := range history.Len() {
+		msg := history.At(i)
+
+		switch msg.Role {
+		case lingograph.Assistant:
+			toolCalls, _ := msg.ModelMetadata.([]functionCallMetadata)
+			if len(toolCalls) == 0 {
+				steps = append(steps, Step{Text: msg.Content})
+				continue
+			}
+
+			for j, toolCall := range toolCalls {
+				step := Step{ToolCall: &ToolCall{
+					ID:        toolCall.param.ID,
+					Name:      toolCall.param.Function.Name,
+					Arguments: toolCall.param.Function.Arguments,
+				}}
+				if j == 0 {
+					step.Text = msg.Content
+				}
+
+				pending[toolCall.param.ID] = len(steps)
+				steps = append(steps, step)
+			}
+		case lingograph.Function:
+			id, ok := msg.ModelMetadata.(functionCallID)
+			if !ok {
+				continue
+			}
+
+			// strip the suffix added for multiple responses (see call)
+			toolCallID := id.ID
+			if k := strings.LastIndexByte(toolCallID, '_'); k >= 0 {
+				toolCallID = toolCallID[:k]
+			}
+
+			if k, ok := pending[toolCallID]; ok {
+				steps[k].Results = append(steps[k].Results, msg.Content)
+			}
+		}
+	}
+
+	return steps
+}
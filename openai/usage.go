@@ -0,0 +1,65 @@
+package openai
+
+import (
+	"maps"
+	"sync"
+
+	"github.com/vasilisp/lingograph/store"
+)
+
+// Usage is a number of tokens used by completions.
+type Usage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+	// Calls is the number of completions.
+	Calls int `json:"calls"`
+}
+
+func (u Usage) add(v Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + v.PromptTokens,
+		CompletionTokens: u.CompletionTokens + v.CompletionTokens,
+		TotalTokens:      u.TotalTokens + v.TotalTokens,
+		Calls:            u.Calls + v.Calls,
+	}
+}
+
+// TokenUsage is the store variable holding the token usage of the completions
+// of the Actors, accumulated over all the calls, e.g., across a chain, by the
+// model that served them, as reported by the API (e.g., "gpt-4o-2024-08-06"),
+// so that per-model pricing can be applied. Every completion updates it. It is
+// part of checkpoints.
+var TokenUsage = store.PersistentVar[map[string]Usage]("openai.token_usage")
+
+// usageMu serializes usage updates of concurrent actors, e.g., within
+// lingograph.Parallel.
+var usageMu sync.Mutex
+
+// TotalUsage returns the token usage of all the models in TokenUsage.
+func TotalUsage(r store.StoreRO) Usage {
+	usage, _ := store.GetRO(r, TokenUsage)
+
+	var total Usage
+	for _, u := range usage {
+		total = total.add(u)
+	}
+
+	return total
+}
+
+func recordUsage(r store.Store, model string, usage Usage) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	old, _ := store.Get(r, TokenUsage)
+
+	// copy, since earlier values may be shared, e.g., by store views
+	updated := maps.Clone(old)
+	if updated == nil {
+		updated = make(map[string]Usage)
+	}
+	updated[model] = updated[model].add(usage)
+
+	store.Set(r, TokenUsage, updated)
+}
@@ -0,0 +1,193 @@
+package openai
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/vasilisp/lingograph/internal/util"
+)
+
+// interaction is a recorded request/response pair.
+type interaction struct {
+	Key         string `json:"key"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body"`
+}
+
+type cassette struct {
+	Interactions []interaction `json:"interactions"`
+}
+
+// requestKey returns the key of a request: a hash of its method and body,
+// which holds the model, the messages and the tools. The path is left out, so
+// that recordings do not depend on the base URL.
+func requestKey(req *http.Request) (string, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return "", err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	h := sha256.New()
+	h.Write([]byte(req.Method + "\n"))
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type recorder struct {
+	mu       sync.Mutex
+	path     string
+	cassette cassette
+}
+
+func (rec *recorder) middleware(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	key, err := requestKey(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := next(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.cassette.Interactions = append(rec.cassette.Interactions, interaction{
+		Key:         key,
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        string(body),
+	})
+
+	data, err := json.MarshalIndent(rec.cassette, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(rec.path, data, 0o644); err != nil {
+		return nil, fmt.Errorf("cannot write recording: %w", err)
+	}
+
+	return resp, nil
+}
+
+// NewRecordingClient creates a Client that makes the requests of underlying,
+// and records every request/response pair to the file at path (overwriting
+// it), for replaying with NewReplayClient, e.g., in tests. underlying has to
+// be created with NewClient or NewClientWithOptions. Requests are keyed by a
+// hash of their body, which holds the model, the messages and the tools; the
+// file has no secrets, since request headers are not recorded. Audio
+// transcriptions are recorded, but cannot be replayed, since their multipart
+// bodies differ every time.
+func NewRecordingClient(underlying Client, path string) Client {
+	cl, ok := underlying.(*client)
+	util.Assert(ok, "NewRecordingClient underlying client not created by NewClient")
+
+	rec := &recorder{path: path}
+
+	options := append(slices.Clone(cl.client.Options), option.WithMiddleware(rec.middleware))
+	recording := openai.NewClient(options...)
+
+	return &client{client: &recording}
+}
+
+type replayer struct {
+	mu           sync.Mutex
+	interactions map[string][]interaction
+	// next holds the index of the next interaction to replay for each key,
+	// so that repeated requests replay their responses in order
+	next map[string]int
+}
+
+func (rep *replayer) middleware(req *http.Request, _ option.MiddlewareNext) (*http.Response, error) {
+	key, err := requestKey(req)
+	if err != nil {
+		return nil, err
+	}
+
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+
+	recorded := rep.interactions[key]
+	if len(recorded) == 0 {
+		return nil, fmt.Errorf("no recorded response for request %s %s (key %s)", req.Method, req.URL.Path, key)
+	}
+
+	// the last response is replayed for further repetitions
+	i := min(rep.next[key], len(recorded)-1)
+	rep.next[key]++
+
+	header := http.Header{}
+	if recorded[i].ContentType != "" {
+		header.Set("Content-Type", recorded[i].ContentType)
+	}
+
+	return &http.Response{
+		Status:        http.StatusText(recorded[i].Status),
+		StatusCode:    recorded[i].Status,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(recorded[i].Body))),
+		ContentLength: int64(len(recorded[i].Body)),
+		Request:       req,
+	}, nil
+}
+
+// NewReplayClient creates a Client that replays the responses recorded by
+// NewRecordingClient in the file at path, without network access or an API
+// key. Identical requests replay their recorded responses in order. Requests
+// that were not recorded fail with an error.
+func NewReplayClient(path string) (Client, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read recording: %w", err)
+	}
+
+	var c cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cannot parse recording: %w", err)
+	}
+
+	if len(c.Interactions) == 0 {
+		return nil, errors.New("empty recording")
+	}
+
+	rep := &replayer{interactions: make(map[string][]interaction), next: make(map[string]int)}
+	for _, interaction := range c.Interactions {
+		rep.interactions[interaction.Key] = append(rep.interactions[interaction.Key], interaction)
+	}
+
+	replay := openai.NewClient(
+		option.WithAPIKey("replay"),
+		option.WithMaxRetries(0),
+		option.WithMiddleware(rep.middleware),
+	)
+
+	return &client{client: &replay}, nil
+}
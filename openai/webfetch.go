@@ -0,0 +1,182 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vasilisp/lingograph/extra"
+	"github.com/vasilisp/lingograph/store"
+)
+
+const (
+	webFetchTimeout  = 15 * time.Second
+	webFetchMaxBytes = 1 << 20
+	webFetchMaxChars = 20000
+)
+
+var (
+	htmlInvisible = regexp.MustCompile(`(?is)<(script|style|noscript|head)\b.*?</(script|style|noscript|head)>`)
+	htmlTag       = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLines    = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// errPrivateAddress is returned when connecting to a non-public address, to
+// protect against server-side request forgery.
+var errPrivateAddress = errors.New("connecting to private addresses is not allowed")
+
+// nonPublicPrefixes are the global unicast ranges that are not reachable on
+// the public internet
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),  // documentation
+	netip.MustParsePrefix("fec0::/10"),      // deprecated site-local
+}
+
+// isPublic reports whether addr is a public unicast address. Loopback,
+// multicast, link-local and unspecified addresses are not global unicast.
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func publicOnly(network, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+
+	if !isPublic(addrPort.Addr()) {
+		return errPrivateAddress
+	}
+
+	return nil
+}
+
+// the check happens on the resolved address of every connection, including
+// redirects, so DNS tricks cannot bypass it
+var webFetchClient = &http.Client{
+	Timeout: webFetchTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: webFetchTimeout,
+			Control: publicOnly,
+		}).DialContext,
+		TLSHandshakeTimeout: webFetchTimeout,
+	},
+}
+
+type webFetchInput struct {
+	URL string `json:"url" jsonschema:"description=The http or https URL to fetch"`
+}
+
+// htmlToText returns the visible text of an HTML document, roughly.
+func htmlToText(s string) string {
+	s = htmlInvisible.ReplaceAllString(s, "")
+	s = htmlTag.ReplaceAllString(s, "\n")
+	s = html.UnescapeString(s)
+	return strings.TrimSpace(blankLines.ReplaceAllString(s, "\n\n"))
+}
+
+func fetchURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webFetchTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := webFetchClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP status %s", response.Status)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, webFetchMaxBytes))
+	if err != nil {
+		return "", err
+	}
+
+	var text string
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		text = htmlToText(string(body))
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || mediaType == "application/xml":
+		text = string(body)
+	default:
+		return "", fmt.Errorf("unsupported content type %q", mediaType)
+	}
+
+	text = extra.SanitizeOutputString(text, false)
+	if runes := []rune(text); len(runes) > webFetchMaxChars {
+		text = string(runes[:webFetchMaxChars]) + "\n[truncated]"
+	}
+
+	return text, nil
+}
+
+// WebFetchTool adds a fetch_url function to the Actor (or FunctionRegistry)
+// that retrieves a web page and returns its text. HTML is reduced to its
+// visible text, and control characters are removed (see
+// extra.SanitizeOutput). Requests time out, responses are capped in size, and
+// connections to private and loopback addresses are blocked. Fetch errors are
+// reported to the model as the function result.
+//
+// Fetched content is untrusted; consider setting a ResultFilter on the Actor.
+func WebFetchTool(a FunctionSet) {
+	AddFunctionUnsafe(a, "fetch_url", "Fetch a web page and return its text content",
+		func(input webFetchInput, r store.Store) ([]string, error) {
+			text, err := fetchURL(input.URL)
+			if err != nil {
+				return []string{fmt.Sprintf("error fetching %s: %v", input.URL, err)}, nil
+			}
+
+			return []string{text}, nil
+		})
+}
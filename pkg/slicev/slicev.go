@@ -17,11 +17,35 @@ type ro[T any] struct {
 	slice []T
 }
 
-// NewRO creates a new read-only wrapper around a slice
+// NewRO creates a new read-only wrapper around a slice. The wrapper aliases
+// the slice: it is not copied, so the caller must not modify the elements of
+// the slice afterwards, or the changes become visible through the wrapper.
+// Appending to the slice is safe, since the wrapper has a fixed length.
 func NewRO[T any](slice []T) RO[T] {
 	return &ro[T]{slice: slice}
 }
 
+// NewROCopy creates a new read-only wrapper around a copy of a slice, which
+// the caller may keep modifying
+func NewROCopy[T any](slice []T) RO[T] {
+	return &ro[T]{slice: append([]T(nil), slice...)}
+}
+
+// Empty creates a read-only slice without elements
+func Empty[T any]() RO[T] {
+	return &ro[T]{}
+}
+
+// FromIterator creates a read-only slice holding the remaining elements of
+// the iterator, which is consumed
+func FromIterator[T any](it Iterator[T]) RO[T] {
+	var slice []T
+	for it.Next() {
+		slice = append(slice, it.Value())
+	}
+	return &ro[T]{slice: slice}
+}
+
 func (r *ro[T]) Len() int {
 	return len(r.slice)
 }
@@ -0,0 +1,130 @@
+package lingograph
+
+import (
+	"time"
+
+	"github.com/vasilisp/lingograph/internal/util"
+	"github.com/vasilisp/lingograph/store"
+)
+
+type retry struct {
+	attempts int
+	// backoff returns the delay after the failed attempt with the given
+	// number, starting from 1
+	backoff  func(attempt int) time.Duration
+	body     Pipeline
+	validate func(Chat) error
+	// retryIf, if not nil, decides which errors are retried
+	retryIf RetryPredicate
+}
+
+// Retry creates a Pipeline that executes body, e.g., a Chain of several
+// steps, up to attempts times, until it succeeds and validate (if not nil)
+// accepts the result. Every attempt starts from the history and the store at
+// the start of Retry: attempts run on a scratch chat, and only the messages,
+// store changes and metadata of the successful attempt reach the chat. Between
+// attempts, Retry waits for backoff, doubled after each attempt, or for the
+// delay of a RetryAfterError. The error of the last attempt is returned if all
+// attempts fail.
+func Retry(attempts int, backoff time.Duration, body Pipeline, validate func(Chat) error) Pipeline {
+	util.Assert(attempts > 0, "Retry non-positive attempts")
+
+	doubling := func(attempt int) time.Duration {
+		return backoff << (attempt - 1)
+	}
+
+	return &retry{attempts: attempts, backoff: doubling, body: body, validate: validate}
+}
+
+// RetryWith creates a Pipeline like Retry, which executes p up to limit times
+// until it succeeds, without validation, and waits for backoff(attempt)
+// after the failed attempt with the given number (starting from 1), e.g., for
+// a constant or jittered schedule, or for the delay of a RetryAfterError. A
+// nil backoff retries immediately. As with
+// Retry, every attempt starts from a snapshot of the history and the store,
+// so failed attempts leave no partial messages behind, and a p that trims
+// only trims the chat if an attempt succeeds.
+func RetryWith(p Pipeline, limit int, backoff func(attempt int) time.Duration) Pipeline {
+	util.Assert(limit > 0, "RetryWith non-positive limit")
+
+	if backoff == nil {
+		backoff = func(int) time.Duration { return 0 }
+	}
+
+	return &retry{attempts: limit, backoff: backoff, body: p}
+}
+
+func (r *retry) attempt(c Chat) (*chat, func(), error) {
+	history := c.History()
+	messages := make([]Message, history.Len())
+	history.CopyTo(messages)
+
+	staged, commit := store.Stage(c.store())
+
+	scratch := &chat{
+		history:            messages,
+		offsetUnique:       len(messages),
+		storeImpl:          staged,
+		onEvent:            forwardEvents(c.record),
+		meta:               c.metadata().copy(),
+		ctx:                c.context(),
+		sequentialParallel: c.sequential(),
+		// the chat applies its own trimming when merging
+		unbounded: true,
+	}
+
+	if err := r.body.Execute(scratch); err != nil {
+		return nil, nil, err
+	}
+
+	if r.validate != nil {
+		if err := r.validate(scratch); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return scratch, commit, nil
+}
+
+func (r *retry) Execute(c Chat) error {
+	var err error
+
+	for i := range r.attempts {
+		var scratch *chat
+		var commit func()
+
+		scratch, commit, err = r.attempt(c)
+		if err == nil {
+			commit()
+			c.metadata().merge(scratch.metadata())
+
+			if r.body.trims() {
+				c.trim()
+			}
+
+			for _, message := range scratch.uniqueMessages() {
+				c.write(message)
+			}
+
+			return nil
+		}
+
+		util.Log.Printf("error executing pipeline (attempt %d of %d): %v", i+1, r.attempts, err)
+
+		if (r.retryIf != nil && !r.retryIf(err)) || c.context().Err() != nil {
+			break
+		}
+
+		if i < r.attempts-1 {
+			if errWait := wait(c.context(), retryDelay(r.backoff, i+1, err)); errWait != nil {
+				return errWait
+			}
+		}
+	}
+
+	return err
+}
+
+func (r *retry) trims() bool {
+	return r.body.trims()
+}
@@ -0,0 +1,68 @@
+package lingograph
+
+import "github.com/vasilisp/lingograph/pkg/slicev"
+
+// scratchpad is the ModelMetadata of the scratchpad message.
+type scratchpad struct {
+	Text string `json:"text"`
+}
+
+func init() {
+	RegisterMetadata[scratchpad]("lingograph.scratchpad")
+}
+
+const scratchpadHeader = "Scratchpad (your running notes and plan):\n"
+
+func isScratchpad(message Message) bool {
+	_, ok := message.ModelMetadata.(scratchpad)
+	return ok
+}
+
+type updateScratchpad struct {
+	fn func(old string) string
+}
+
+// UpdateScratchpad creates a Pipeline that maintains a scratchpad message,
+// e.g., a running plan of an agent. fn receives the current text of the
+// scratchpad (empty if there is none), and returns the new text. The first
+// update writes the scratchpad as a pinned user message; later updates edit it
+// in place instead of appending, so the model sees a single, current
+// scratchpad every turn. Edits within the branches of Parallel do not reach
+// the parent chat.
+func UpdateScratchpad(fn func(old string) string) Pipeline {
+	return &updateScratchpad{fn: fn}
+}
+
+// Scratchpad returns the text of the scratchpad of the history (see
+// UpdateScratchpad), and whether there is one.
+func Scratchpad(history slicev.RO[Message]) (string, bool) {
+	for i := history.Len() - 1; i >= 0; i-- {
+		if pad, ok := history.At(i).ModelMetadata.(scratchpad); ok {
+			return pad.Text, true
+		}
+	}
+
+	return "", false
+}
+
+func (u *updateScratchpad) Execute(chat Chat) error {
+	old, _ := Scratchpad(chat.History())
+
+	text := u.fn(old)
+	message := Message{
+		Role:          User,
+		Content:       scratchpadHeader + text,
+		ModelMetadata: scratchpad{Text: text},
+		Pinned:        true,
+	}
+
+	if !chat.edit(isScratchpad, message) {
+		chat.write(message)
+	}
+
+	return nil
+}
+
+func (u *updateScratchpad) trims() bool {
+	return false
+}
@@ -0,0 +1,75 @@
+package lingograph
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/vasilisp/lingograph/pkg/slicev"
+	"github.com/vasilisp/lingograph/store"
+)
+
+// ScriptedActor is an Actor that writes pre-canned responses, e.g., for
+// testing the composition of pipelines without calling a model.
+type ScriptedActor interface {
+	Actor
+	// Calls returns the number of times the Actor was invoked, including
+	// the invocations that failed because the script was exhausted.
+	Calls() int
+	// Histories returns copies of the histories the Actor was invoked with,
+	// in order.
+	Histories() [][]Message
+}
+
+type scriptedActor struct {
+	Actor
+	mu        sync.Mutex
+	responses []string
+	histories [][]Message
+}
+
+// NewScriptedActor creates a ScriptedActor that writes the responses as
+// assistant messages, one per invocation, in order. Invocations past the end
+// of the script fail. The Actor is safe for concurrent use, e.g., within
+// Parallel, although the order of the responses then depends on scheduling.
+func NewScriptedActor(responses []string) ScriptedActor {
+	a := &scriptedActor{responses: responses}
+
+	a.Actor = NewActor(Assistant, func(history slicev.RO[Message], _ store.Store) (string, error) {
+		messages := make([]Message, history.Len())
+		history.CopyTo(messages)
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		i := len(a.histories)
+		a.histories = append(a.histories, messages)
+
+		if i >= len(a.responses) {
+			return "", fmt.Errorf("scripted actor invoked %d times, with %d responses", i+1, len(a.responses))
+		}
+
+		return a.responses[i], nil
+	})
+
+	return a
+}
+
+func (a *scriptedActor) Unwrap() Actor {
+	return a.Actor
+}
+
+func (a *scriptedActor) Calls() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(a.histories)
+}
+
+func (a *scriptedActor) Histories() [][]Message {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	histories := make([][]Message, len(a.histories))
+	copy(histories, a.histories)
+	return histories
+}
@@ -0,0 +1,228 @@
+package lingograph
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vasilisp/lingograph/internal/util"
+)
+
+// Splitter splits the content of an oversized message into chunks of at most
+// maxTokens tokens each.
+type Splitter func(content string, maxTokens int) []string
+
+// Combiner combines the messages written by a pipeline for each chunk of an
+// oversized message (see SplitOversized) into the messages written to the
+// chat.
+type Combiner func(results [][]Message) ([]Message, error)
+
+// ApproxTokens estimates the number of tokens of text: 4 ASCII characters per
+// token, which is typical for English text and common tokenizers, and a token
+// per non-ASCII character, since byte counts overestimate accented text and
+// underestimate CJK text badly.
+func ApproxTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+
+	return (ascii+3)/4 + other
+}
+
+// cutTokens splits text after its longest prefix of at most maxTokens tokens
+// (see ApproxTokens), at a character boundary. The prefix holds at least one
+// character, so that splitting makes progress.
+func cutTokens(text string, maxTokens int) (string, string) {
+	ascii, other := 0, 0
+	for i, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+
+		if i > 0 && (ascii+3)/4+other > maxTokens {
+			return text[:i], text[i:]
+		}
+	}
+
+	return text, ""
+}
+
+// ParagraphSplitter splits content at paragraph boundaries, and falls back to
+// line and word boundaries for paragraphs that are too long. Tokens are
+// estimated with ApproxTokens. Words longer than a chunk are split between
+// characters.
+func ParagraphSplitter(content string, maxTokens int) []string {
+	maxTokens = max(1, maxTokens)
+
+	var chunks []string
+	var b strings.Builder
+	// tokens bounds the tokens of b from above
+	tokens := 0
+
+	flush := func() {
+		if b.Len() > 0 {
+			chunks = append(chunks, b.String())
+			b.Reset()
+			tokens = 0
+		}
+	}
+
+	var add func(piece string, separators []string)
+	add = func(piece string, separators []string) {
+		pieceTokens := ApproxTokens(piece)
+
+		if pieceTokens > maxTokens {
+			flush()
+
+			if len(separators) == 0 {
+				for ApproxTokens(piece) > maxTokens {
+					var chunk string
+					chunk, piece = cutTokens(piece, maxTokens)
+					chunks = append(chunks, chunk)
+				}
+				b.WriteString(piece)
+				tokens = ApproxTokens(piece)
+				return
+			}
+
+			for i, part := range strings.Split(piece, separators[0]) {
+				if i > 0 {
+					part = separators[0] + part
+				}
+				add(part, separators[1:])
+			}
+			return
+		}
+
+		if tokens+pieceTokens > maxTokens {
+			flush()
+		}
+		b.WriteString(piece)
+		tokens += pieceTokens
+	}
+
+	add(content, []string{"\n\n", "\n", " "})
+	flush()
+
+	return chunks
+}
+
+// JoinCombiner returns a Combiner that joins the contents of the last message
+// written for each chunk with separator, into a single message with the role
+// of the last message.
+func JoinCombiner(separator string) Combiner {
+	return func(results [][]Message) ([]Message, error) {
+		var combined Message
+		contents := make([]string, 0, len(results))
+
+		for _, messages := range results {
+			if len(messages) == 0 {
+				continue
+			}
+			combined = messages[len(messages)-1]
+			contents = append(contents, combined.Content)
+		}
+
+		if len(contents) == 0 {
+			return nil, nil
+		}
+
+		combined.Content = strings.Join(contents, separator)
+		combined.Parts = nil
+
+		return []Message{combined}, nil
+	}
+}
+
+type splitOversized struct {
+	pipeline  Pipeline
+	maxTokens int
+	split     Splitter
+	combine   Combiner
+}
+
+// SplitOversized creates a Pipeline that executes the given pipeline, e.g., an
+// actor, normally if the last message of the history fits in maxTokens
+// tokens. Otherwise, the content of the last message is split into chunks,
+// the pipeline is executed once per chunk (map) on a history where the chunk
+// replaces the last message, and the messages written for all chunks are
+// combined (reduce) and written to the chat. This is useful for feeding large
+// documents to a model with a limited context window. Tokens are estimated
+// with ApproxTokens. If split or combine are nil, ParagraphSplitter and
+// JoinCombiner("\n\n") are used.
+func SplitOversized(pipeline Pipeline, maxTokens int, split Splitter, combine Combiner) Pipeline {
+	util.Assert(maxTokens > 0, "SplitOversized non-positive maxTokens")
+
+	if split == nil {
+		split = ParagraphSplitter
+	}
+	if combine == nil {
+		combine = JoinCombiner("\n\n")
+	}
+
+	return &splitOversized{pipeline: pipeline, maxTokens: maxTokens, split: split, combine: combine}
+}
+
+func (s *splitOversized) Execute(c Chat) error {
+	history := c.History()
+	if history.Len() == 0 || ApproxTokens(history.At(history.Len()-1).Content) <= s.maxTokens {
+		return s.pipeline.Execute(c)
+	}
+
+	prefix := make([]Message, history.Len()-1)
+	history.CopyTo(prefix)
+	last := history.At(history.Len() - 1)
+
+	chunks := s.split(last.Content, s.maxTokens)
+	results := make([][]Message, 0, len(chunks))
+
+	for _, chunk := range chunks {
+		message := last
+		message.Content = chunk
+		message.Parts = nil
+
+		messages := make([]Message, len(prefix), len(prefix)+1)
+		copy(messages, prefix)
+
+		scratch := &chat{
+			history:            append(messages, message),
+			storeImpl:          c.store(),
+			onEvent:            forwardEvents(c.record),
+			meta:               c.metadata(),
+			ctx:                c.context(),
+			sequentialParallel: c.sequential(),
+		}
+
+		written, err := ExecuteMessages(s.pipeline, scratch)
+		if err != nil {
+			return err
+		}
+
+		results = append(results, written)
+	}
+
+	combined, err := s.combine(results)
+	if err != nil {
+		return err
+	}
+
+	if s.pipeline.trims() {
+		c.trim()
+	}
+
+	for _, message := range combined {
+		c.write(message)
+	}
+
+	return nil
+}
+
+func (s *splitOversized) trims() bool {
+	return s.pipeline.trims()
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/vasilisp/lingograph/internal/util"
+)
+
+type persistentVar struct {
+	id     int64
+	decode func(json.RawMessage) (any, error)
+}
+
+// persistentVars maps the names of persistent vars to their IDs and decoders
+var persistentVars sync.Map
+
+// PersistentVar creates a new Var like FreshVar, and registers it under the
+// given name, so that its value is part of Snapshot and Restore. The value has
+// to be JSON-serializable. Names have to be unique; persistent vars are meant
+// to be created during initialization, e.g., as package-level variables.
+func PersistentVar[T any](name string) Var[T] {
+	v := FreshVar[T]()
+
+	decode := func(raw json.RawMessage) (any, error) {
+		var val T
+		if err := json.Unmarshal(raw, &val); err != nil {
+			return nil, err
+		}
+		return val, nil
+	}
+
+	_, loaded := persistentVars.LoadOrStore(name, persistentVar{id: v.id, decode: decode})
+	util.Assert(!loaded, "PersistentVar duplicate name "+name)
+
+	return v
+}
+
+// Snapshot returns the JSON-encoded values of the persistent vars (see
+// PersistentVar) that are set in r, keyed by name. Other vars are not
+// included.
+func Snapshot(r Store) (map[string]json.RawMessage, error) {
+	values := make(map[string]json.RawMessage)
+
+	r.lock().RLock()
+	defer r.lock().RUnlock()
+
+	var err error
+	persistentVars.Range(func(key, value any) bool {
+		name := key.(string)
+
+		val, ok := r.vars().Load(value.(persistentVar).id)
+		if !ok {
+			return true
+		}
+
+		var raw []byte
+		raw, err = json.Marshal(val)
+		if err != nil {
+			err = fmt.Errorf("cannot encode %s: %w", name, err)
+			return false
+		}
+
+		values[name] = raw
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// Restore creates a new Store holding the values of a Snapshot. It fails if a
+// name does not belong to a persistent var, e.g., because the var has been
+// renamed.
+func Restore(values map[string]json.RawMessage) (Store, error) {
+	r := NewStore()
+
+	for name, raw := range values {
+		value, ok := persistentVars.Load(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown persistent var %s", name)
+		}
+		v := value.(persistentVar)
+
+		val, err := v.decode(raw)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode %s: %w", name, err)
+		}
+
+		r.set(v.id, val)
+	}
+
+	return r, nil
+}
+
+// GetNamed retrieves the value of the persistent var with the given name (see
+// PersistentVar) from the read-only Store, e.g., for templates. The second
+// return value indicates whether the var exists and is set.
+func GetNamed(r StoreRO, name string) (any, bool) {
+	value, ok := persistentVars.Load(name)
+	if !ok {
+		return nil, false
+	}
+
+	return r.store().vars().Load(value.(persistentVar).id)
+}
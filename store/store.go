@@ -14,27 +14,114 @@ type Store interface {
 	// RO returns a read-only view of the Store.
 	RO() StoreRO
 	vars() *sync.Map
+	set(id int64, val any)
+	lock() *sync.RWMutex
+	watch(id int64, fn func(any)) func()
 }
 
 // store is a heterogeneous key-value map.
 type store struct {
 	varsMap *sync.Map
+	hook    func(id int64, val any)
+	// mu is held for writing by set, and for reading by Copy and View, so
+	// that copies are consistent
+	mu sync.RWMutex
+	// watchers maps var IDs to the watchers of the var, keyed by watcher ID
+	watchersMu sync.Mutex
+	watchers   map[int64]map[int64]func(any)
+	nextWatch  int64
 }
 
 func (s *store) vars() *sync.Map {
 	return s.varsMap
 }
 
+func (s *store) set(id int64, val any) {
+	s.mu.Lock()
+	s.varsMap.Store(id, val)
+	s.mu.Unlock()
+
+	if s.hook != nil {
+		s.hook(id, val)
+	}
+
+	s.watchersMu.Lock()
+	watchers := make([]func(any), 0, len(s.watchers[id]))
+	for _, fn := range s.watchers[id] {
+		watchers = append(watchers, fn)
+	}
+	s.watchersMu.Unlock()
+
+	for _, fn := range watchers {
+		fn(val)
+	}
+}
+
+func (s *store) watch(id int64, fn func(any)) func() {
+	s.watchersMu.Lock()
+	defer s.watchersMu.Unlock()
+
+	if s.watchers == nil {
+		s.watchers = make(map[int64]map[int64]func(any))
+	}
+	if s.watchers[id] == nil {
+		s.watchers[id] = make(map[int64]func(any))
+	}
+
+	s.nextWatch++
+	watchID := s.nextWatch
+	s.watchers[id][watchID] = fn
+
+	return func() {
+		s.watchersMu.Lock()
+		defer s.watchersMu.Unlock()
+
+		delete(s.watchers[id], watchID)
+	}
+}
+
+func (s *store) lock() *sync.RWMutex {
+	return &s.mu
+}
+
 // NewStore creates a new Store.
 func NewStore() Store {
 	return &store{varsMap: &sync.Map{}}
 }
 
+// NewStoreWithHook creates a new Store that calls hook after every Set, with
+// the ID of the Var and the new value.
+func NewStoreWithHook(hook func(id int64, val any)) Store {
+	return &store{varsMap: &sync.Map{}, hook: hook}
+}
+
+// Copy creates a new Store holding the same values as r at a single point in
+// time: concurrent Sets are either fully visible in the copy or not at all.
+// Later changes to either Store are not visible in the other one.
+func Copy(r Store) Store {
+	vars := &sync.Map{}
+
+	r.lock().RLock()
+	defer r.lock().RUnlock()
+
+	r.vars().Range(func(key, value any) bool {
+		vars.Store(key, value)
+		return true
+	})
+
+	return &store{varsMap: vars}
+}
+
 // Var is a unique identifier for a variable in the Store.
 type Var[T any] struct {
 	id int64
 }
 
+// ID returns the unique ID of the Var, e.g., for identifying it in logs.
+func (v Var[T]) ID() int64 {
+	return v.id
+}
+
 // FreshVar creates a new Var with a unique ID.
 func FreshVar[T any]() Var[T] {
 	return Var[T]{id: atomic.AddInt64(&nextID, 1)}
@@ -60,7 +147,19 @@ func Get[T any](r Store, v Var[T]) (T, bool) {
 
 // Set sets the value of a Var in the Store.
 func Set[T any](r Store, v Var[T], val T) {
-	r.vars().Store(v.id, val)
+	r.set(v.id, val)
+}
+
+// Watch calls fn with the new value whenever v is set in r, until the returned
+// function is called. fn runs synchronously in the goroutine that sets the
+// value, after the value is visible to Get, so watchers of vars set by
+// concurrent pipelines (e.g., within Parallel) may run concurrently, and must
+// be safe for that. fn must not block, since it delays the setter. Copies of r
+// (see Copy) do not inherit the watchers.
+func Watch[T any](r Store, v Var[T], fn func(newVal T)) func() {
+	return r.watch(v.id, func(val any) {
+		fn(val.(T))
+	})
 }
 
 // StoreRO is a read-only view of a Store.
@@ -80,8 +179,47 @@ func (r *store) RO() StoreRO {
 	return &storeRO{r: r}
 }
 
+// View returns a point-in-time read-only view of r: unlike r.RO(), it does not
+// observe Sets that happen after View returns, e.g., by concurrent pipelines,
+// so a series of reads through it is consistent.
+func View(r Store) StoreRO {
+	return Copy(r).RO()
+}
+
 // GetRO retrieves the value of a Var from the read-only Store. The second
 // return value indicates whether the variable was bound.
 func GetRO[T any](r StoreRO, v Var[T]) (T, bool) {
 	return Get(r.store(), v)
 }
+
+// Stage creates a copy of r (see Copy) for tentative changes, and a function
+// that commits the Sets made on the copy to r, in order. Values that were not
+// set on the copy are left alone in r, even if they changed concurrently.
+func Stage(r Store) (Store, func()) {
+	type change struct {
+		id  int64
+		val any
+	}
+
+	var mu sync.Mutex
+	var changes []change
+
+	staged := Copy(r).(*store)
+	staged.hook = func(id int64, val any) {
+		mu.Lock()
+		changes = append(changes, change{id: id, val: val})
+		mu.Unlock()
+	}
+
+	commit := func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, c := range changes {
+			r.set(c.id, c.val)
+		}
+		changes = nil
+	}
+
+	return staged, commit
+}
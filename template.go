@@ -0,0 +1,240 @@
+package lingograph
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/vasilisp/lingograph/store"
+)
+
+// PromptTemplate is a prompt composed of text/template files (see
+// LoadPromptTemplate).
+type PromptTemplate struct {
+	name string
+	set  *template.Template
+}
+
+// includePlaceholder stands in for include while parsing; rendering binds
+// include to the data of the render
+func includePlaceholder(string) (string, error) {
+	return "", nil
+}
+
+// LoadPromptTemplate parses the template file name of fsys (e.g., os.DirFS of
+// a prompts directory), and the files it includes, transitively. A file
+// includes another one with {{include "other.tmpl"}}, which is replaced by the
+// other file rendered against the same data. Include paths are relative to the
+// root of fsys, and must be string literals. Missing files and include cycles
+// are reported as errors.
+func LoadPromptTemplate(fsys fs.FS, name string) (*PromptTemplate, error) {
+	name = path.Clean(name)
+	set := template.New("").Funcs(template.FuncMap{"include": includePlaceholder})
+
+	var load func(name string, stack []string) error
+	load = func(name string, stack []string) error {
+		if i := slices.Index(stack, name); i >= 0 {
+			cycle := append(stack[i:], name)
+			return fmt.Errorf("prompt template include cycle: %s", strings.Join(cycle, " -> "))
+		}
+
+		if set.Lookup(name) != nil {
+			return nil
+		}
+
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return fmt.Errorf("prompt template %s: %w", name, err)
+		}
+
+		t, err := set.New(name).Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("prompt template %s: %w", name, err)
+		}
+
+		stack = append(stack, name)
+		for _, include := range includes(t.Tree.Root) {
+			if err := load(path.Clean(include), stack); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := load(name, nil); err != nil {
+		return nil, err
+	}
+
+	return &PromptTemplate{name: name, set: set}, nil
+}
+
+// includes returns the arguments of the include calls under node
+func includes(node parse.Node) []string {
+	var names []string
+
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for i, arg := range n.Args {
+				if ident, ok := arg.(*parse.IdentifierNode); ok && ident.Ident == "include" && i+1 < len(n.Args) {
+					if s, ok := n.Args[i+1].(*parse.StringNode); ok {
+						names = append(names, s.Text)
+					}
+				}
+				walk(arg)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		}
+	}
+
+	walk(node)
+	return names
+}
+
+// Render renders the template against data.
+func (t *PromptTemplate) Render(data any) (string, error) {
+	set, err := t.set.Clone()
+	if err != nil {
+		return "", err
+	}
+
+	set.Funcs(template.FuncMap{"include": func(name string) (string, error) {
+		var b strings.Builder
+		if err := set.ExecuteTemplate(&b, path.Clean(name), data); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}})
+
+	var b strings.Builder
+	if err := set.ExecuteTemplate(&b, t.name, data); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+type templatePipeline struct {
+	template *PromptTemplate
+	data     func(store.StoreRO) any
+	trim     bool
+}
+
+// Pipeline creates a Pipeline that renders the template and writes the result
+// as a user message. The data of the template is computed from a point-in-time
+// view of the store by data, which may be nil. If trim is true, it clears the
+// chat history before writing the message. Rendering errors are returned by
+// Execute.
+func (t *PromptTemplate) Pipeline(data func(store.StoreRO) any, trim bool) Pipeline {
+	return &templatePipeline{template: t, data: data, trim: trim}
+}
+
+func (p *templatePipeline) Execute(chat Chat) error {
+	var data any
+	if p.data != nil {
+		data = p.data(store.View(chat.store()))
+	}
+
+	content, err := p.template.Render(data)
+	if err != nil {
+		return err
+	}
+
+	if p.trim {
+		chat.trim()
+	}
+
+	chat.write(Message{Role: User, Content: content})
+
+	return nil
+}
+
+func (p *templatePipeline) trims() bool {
+	return p.trim
+}
+
+// storeData is the data of templates rendered against the store
+type storeData struct {
+	r store.StoreRO
+}
+
+// Var returns the value of the persistent var with the given name, and fails
+// if there is no such var or it is not set.
+func (d storeData) Var(name string) (any, error) {
+	value, ok := store.GetNamed(d.r, name)
+	if !ok {
+		return nil, fmt.Errorf("persistent var %s is not set", name)
+	}
+	return value, nil
+}
+
+// Has reports whether the persistent var with the given name is set.
+func (d storeData) Has(name string) bool {
+	_, ok := store.GetNamed(d.r, name)
+	return ok
+}
+
+// StoreData returns template data for rendering against r: {{.Var "name"}}
+// is the value of the persistent var with the given name (see
+// store.PersistentVar), and {{.Has "name"}} reports whether it is set. Vars
+// created with store.FreshVar have no name, so they cannot be referred to;
+// pass a data function of your own to PromptTemplate.Pipeline to render them.
+// StoreData can be passed as the data function of PromptTemplate.Pipeline.
+func StoreData(r store.StoreRO) any {
+	return storeData{r: r}
+}
+
+// UserPromptTemplate creates a Pipeline like UserPrompt, whose message is the
+// text/template tmpl rendered against a point-in-time view of the store when
+// the Pipeline executes (see StoreData), e.g., "Hello {{.Var "username"}}".
+// Only persistent vars can be referred to by name. A var that is missing or
+// not set fails the Pipeline with an error, instead of rendering as empty;
+// use {{if .Has "name"}} for optional vars. An error is returned if tmpl
+// cannot be parsed.
+func UserPromptTemplate(tmpl string, trim bool) (Pipeline, error) {
+	t, err := template.New("prompt").Funcs(template.FuncMap{"include": includePlaceholder}).Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("prompt template: %w", err)
+	}
+
+	prompt := &PromptTemplate{name: "prompt", set: t}
+
+	return prompt.Pipeline(StoreData, trim), nil
+}
@@ -0,0 +1,113 @@
+package lingograph
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vasilisp/lingograph/internal/util"
+	"github.com/vasilisp/lingograph/store"
+)
+
+type timeout struct {
+	d        time.Duration
+	pipeline Pipeline
+}
+
+// Timeout creates a Pipeline that executes pipeline, and fails with an error
+// wrapping context.DeadlineExceeded if it does not finish within d, e.g., to
+// guard against a hung provider. The pipeline runs on a snapshot of the
+// history and the store (see Retry), so the chat is left unmodified on
+// timeout. On timeout, the context of the pipeline is cancelled (see
+// WithChatContext), which stops the requests of the providers and the actors
+// created with NewActorContext; other actors keep running in the background.
+// The messages, store changes, metadata and events of a timed-out pipeline are
+// discarded. d must be positive.
+func Timeout(d time.Duration, pipeline Pipeline) Pipeline {
+	util.Assert(d > 0, "Timeout non-positive duration")
+
+	return &timeout{d: d, pipeline: pipeline}
+}
+
+func (t *timeout) Execute(c Chat) error {
+	history := c.History()
+	messages := make([]Message, history.Len())
+	history.CopyTo(messages)
+
+	staged, commit := store.Stage(c.store())
+
+	// events are forwarded to the chat until the timeout
+	var mu sync.Mutex
+	expired := false
+	forward := forwardEvents(c.record)
+
+	ctx, cancel := context.WithTimeout(c.context(), t.d)
+	defer cancel()
+
+	scratch := &chat{
+		history:      messages,
+		offsetUnique: len(messages),
+		storeImpl:    staged,
+		onEvent: func(event Event) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if !expired {
+				forward(event)
+			}
+		},
+		meta:               c.metadata().copy(),
+		ctx:                ctx,
+		sequentialParallel: c.sequential(),
+		// the chat applies its own trimming when merging
+		unbounded: true,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- t.pipeline.Execute(scratch)
+	}()
+
+	expire := func() error {
+		mu.Lock()
+		expired = true
+		mu.Unlock()
+
+		if err := c.context().Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("pipeline timed out after %v: %w", t.d, context.DeadlineExceeded)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			// the pipeline may fail with the error of its cancelled context
+			if ctx.Err() != nil {
+				return expire()
+			}
+			return err
+		}
+	case <-ctx.Done():
+		return expire()
+	}
+
+	commit()
+
+	c.metadata().merge(scratch.metadata())
+
+	if t.pipeline.trims() {
+		c.trim()
+	}
+
+	for _, message := range scratch.uniqueMessages() {
+		c.write(message)
+	}
+
+	return nil
+}
+
+func (t *timeout) trims() bool {
+	return t.pipeline.trims()
+}
@@ -0,0 +1,265 @@
+package lingograph
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vasilisp/lingograph/internal/schema"
+	"github.com/vasilisp/lingograph/store"
+)
+
+// Tool is a provider-neutral function that a model can call. Each provider
+// adapts Tools to its own function calling API (e.g., openai.AddTool), so that
+// the same Tool can be used across providers.
+type Tool struct {
+	// Name is the name of the function, as seen by the model.
+	Name string
+	// Description tells the model what the function does.
+	Description string
+	// Schema is the JSON schema of the function arguments.
+	Schema map[string]any
+	// Handler receives the arguments as JSON, and returns the result
+	// messages. Function messages are tool results; other messages (e.g., User
+	// messages with images) are placed after the tool results.
+	Handler func(arguments string, r store.Store) ([]Message, error)
+	// Format is the format of the results, which providers present to the
+	// model, e.g., by fencing them. The zero value is FormatRaw.
+	Format ResultFormat
+}
+
+// ResultFormat is the content type of the results of a Tool.
+type ResultFormat uint8
+
+const (
+	// FormatRaw results are passed to the model as they are.
+	FormatRaw ResultFormat = iota
+	FormatJSON
+	FormatMarkdown
+	FormatCSV
+)
+
+// Fence wraps content in a fenced block tagged with the format, e.g.,
+// "```json", so that the model knows how to parse it. Raw content is returned
+// unchanged.
+func (f ResultFormat) Fence(content string) string {
+	var tag string
+	switch f {
+	case FormatJSON:
+		tag = "json"
+	case FormatMarkdown:
+		tag = "markdown"
+	case FormatCSV:
+		tag = "csv"
+	default:
+		return content
+	}
+
+	return "```" + tag + "\n" + content + "\n```"
+}
+
+// WithFormat returns a copy of the Tool with the given result format.
+func (t Tool) WithFormat(format ResultFormat) Tool {
+	t.Format = format
+	return t
+}
+
+// DecodeOption configures how the arguments of a Tool are decoded.
+type DecodeOption func(*json.Decoder)
+
+// DisallowUnknownFields makes arguments with fields that are not part of the
+// input type fail to decode, instead of being dropped silently, e.g., when the
+// model hallucinates parameters.
+func DisallowUnknownFields() DecodeOption {
+	return (*json.Decoder).DisallowUnknownFields
+}
+
+// UseNumber decodes numbers in arguments of type any as json.Number instead
+// of float64, so that large integers are not misparsed.
+func UseNumber() DecodeOption {
+	return (*json.Decoder).UseNumber
+}
+
+// NewToolUnsafe creates a Tool whose schema is reflected from I. The handler
+// produces arbitrary messages. The arguments are decoded with the given
+// options; arguments that fail to decode are reported to the model (see
+// RecoverableError).
+func NewToolUnsafe[I any](name string, description string, fn func(I, store.Store) ([]Message, error), options ...DecodeOption) Tool {
+	return Tool{
+		Name:        name,
+		Description: description,
+		Schema:      schema.Reflect[I](),
+		Handler: func(arguments string, r store.Store) ([]Message, error) {
+			decoder := json.NewDecoder(strings.NewReader(arguments))
+			for _, option := range options {
+				option(decoder)
+			}
+
+			var i I
+			if err := decoder.Decode(&i); err != nil {
+				return nil, RecoverableError(fmt.Errorf("invalid arguments: %w", err))
+			}
+
+			return fn(i, r)
+		},
+	}
+}
+
+// NewTool creates a Tool whose schema is reflected from I. The output will be
+// marshaled to JSON, unless it is an ImageResult or a DisplayResult. The
+// arguments are decoded as in NewToolUnsafe.
+func NewTool[I any, O any](name string, description string, fn func(I, store.Store) (O, error), options ...DecodeOption) Tool {
+	return NewToolUnsafe(name, description, func(i I, r store.Store) ([]Message, error) {
+		o, err := fn(i, r)
+		if err != nil {
+			return nil, err
+		}
+
+		if image, ok := any(o).(ImageResult); ok {
+			return image.messages(name), nil
+		}
+
+		if result, ok := any(o).(DisplayResult); ok {
+			return result.messages()
+		}
+
+		json, err := json.Marshal(o)
+		if err != nil {
+			return nil, err
+		}
+
+		return []Message{{Role: Function, Content: string(json)}}, nil
+	}, options...)
+}
+
+// ImageResult is a function result holding an image. Since function results
+// are text-only, the model sees the image in a user message following the
+// function results.
+type ImageResult struct {
+	// Data is the encoded image.
+	Data []byte
+	// MediaType is the MIME type of the image, e.g., "image/png".
+	MediaType string
+}
+
+func (image ImageResult) messages(name string) []Message {
+	url := "data:" + image.MediaType + ";base64," + base64.StdEncoding.EncodeToString(image.Data)
+
+	return []Message{
+		{Role: Function, Content: "The image is attached in the next message."},
+		NewMessageParts(
+			User,
+			TextPart(fmt.Sprintf("Image returned by function %s:", name)),
+			ImagePart(url),
+		),
+	}
+}
+
+// DisplayResult is a function result with separate versions for the model
+// and for people, e.g., compact JSON for the model and a table for the user.
+// The model sees Model marshaled to JSON, while Display becomes the Display of
+// the function message, which echo callbacks and observers can show (see
+// Message.DisplayContent).
+type DisplayResult struct {
+	Model   any
+	Display string
+}
+
+func (result DisplayResult) messages() ([]Message, error) {
+	json, err := json.Marshal(result.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	return []Message{{Role: Function, Content: string(json), Display: result.Display}}, nil
+}
+
+// ToolDisposition tells how a failed tool call is handled.
+type ToolDisposition uint8
+
+const (
+	// ToolFatal aborts the turn of the Actor; it is the disposition of errors
+	// that are not ToolErrors.
+	ToolFatal ToolDisposition = iota
+	// ToolRecoverable feeds the error back to the model as the result of the
+	// tool call, so that it can correct the call.
+	ToolRecoverable
+	// ToolRetryable retries the tool call, e.g., after a transient failure.
+	// If the retries fail as well, the turn is aborted.
+	ToolRetryable
+)
+
+// ToolError is an error of a Tool handler that carries its disposition.
+// Providers inspect the errors of handlers with errors.As.
+type ToolError interface {
+	error
+	Disposition() ToolDisposition
+}
+
+type toolError struct {
+	err         error
+	disposition ToolDisposition
+}
+
+func (e *toolError) Error() string {
+	return e.err.Error()
+}
+
+func (e *toolError) Unwrap() error {
+	return e.err
+}
+
+func (e *toolError) Disposition() ToolDisposition {
+	return e.disposition
+}
+
+// RecoverableError wraps err in a ToolError that is fed back to the model.
+func RecoverableError(err error) error {
+	return &toolError{err: err, disposition: ToolRecoverable}
+}
+
+const (
+	// toolRetryLimit is the number of retries of tool calls failing with
+	// retryable errors
+	toolRetryLimit = 2
+	// toolRetryBackoff is the delay before the first retry; it doubles for
+	// every further retry
+	toolRetryBackoff = 500 * time.Millisecond
+)
+
+// DispositionOf returns how a tool call failing with err is handled: the
+// disposition of the first ToolError in the chain of err, or ToolFatal.
+func DispositionOf(err error) ToolDisposition {
+	var toolErr ToolError
+	if errors.As(err, &toolErr) {
+		return toolErr.Disposition()
+	}
+	return ToolFatal
+}
+
+// CallTool calls handler with the arguments, and retries the call up to twice,
+// with a growing backoff, while it fails with a ToolRetryable error. Providers
+// use it for executing tool calls; the caller handles the remaining error
+// according to DispositionOf. Once ctx is done, CallTool stops waiting for a
+// retry and fails with the error of ctx.
+func CallTool(ctx context.Context, handler func(string, store.Store) ([]Message, error), arguments string, r store.Store) ([]Message, error) {
+	messages, err := handler(arguments, r)
+	for i := 0; i < toolRetryLimit && DispositionOf(err) == ToolRetryable; i++ {
+		if errWait := wait(ctx, time.Duration(1<<i)*toolRetryBackoff); errWait != nil {
+			return nil, errWait
+		}
+		messages, err = handler(arguments, r)
+	}
+
+	return messages, err
+}
+
+// RetryableError wraps err in a ToolError that causes the tool call to be
+// retried.
+func RetryableError(err error) error {
+	return &toolError{err: err, disposition: ToolRetryable}
+}
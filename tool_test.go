@@ -0,0 +1,51 @@
+package lingograph
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/vasilisp/lingograph/store"
+)
+
+type toolInput struct {
+	City string `json:"city"`
+}
+
+func TestNewToolUnsafeDecoding(t *testing.T) {
+	tests := []struct {
+		name        string
+		arguments   string
+		options     []DecodeOption
+		recoverable bool
+	}{
+		{name: "valid", arguments: `{"city": "Athens"}`},
+		{name: "unknown field dropped", arguments: `{"city": "Athens", "country": "GR"}`},
+		{name: "unknown field disallowed", arguments: `{"city": "Athens", "country": "GR"}`, options: []DecodeOption{DisallowUnknownFields()}, recoverable: true},
+		{name: "malformed", arguments: `{"city":`, recoverable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool := NewToolUnsafe("weather", "", func(i toolInput, _ store.Store) ([]Message, error) {
+				return []Message{{Role: Function, Content: i.City}}, nil
+			}, tt.options...)
+
+			messages, err := tool.Handler(tt.arguments, store.NewStore())
+
+			if !tt.recoverable {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(messages) != 1 || messages[0].Content != "Athens" {
+					t.Errorf("messages = %v, want the city", messages)
+				}
+				return
+			}
+
+			var toolErr ToolError
+			if !errors.As(err, &toolErr) || toolErr.Disposition() != ToolRecoverable {
+				t.Errorf("error = %v, want a recoverable ToolError", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package lingograph
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/vasilisp/lingograph/store"
+)
+
+// Tree is a node in a tree of conversations. Every node holds a Chat, and the
+// Chat of a child node starts from a snapshot of the history and the store of
+// its parent at the time of branching.
+type Tree interface {
+	// Chat returns the conversation of the node.
+	Chat() Chat
+	// Branch creates a new child node, starting from the current state of the
+	// node's conversation.
+	Branch() Tree
+	// Children returns the child nodes, in the order they were created.
+	Children() []Tree
+	// Parent returns the parent node, or nil for the root.
+	Parent() Tree
+}
+
+type tree struct {
+	chat     Chat
+	parent   *tree
+	mu       sync.Mutex
+	children []Tree
+}
+
+// NewTree creates the root of a new conversation tree holding the given chat.
+func NewTree(chat Chat) Tree {
+	return &tree{chat: chat}
+}
+
+func (t *tree) Chat() Chat {
+	return t.chat
+}
+
+// branchChat returns a new chat with a snapshot of the history, the store and
+// the metadata of c. When possible, the history shares its backing array with c, and is
+// copied on the first write of either chat.
+func branchChat(c Chat) *chat {
+	var history []Message
+	unbounded, trimStrategy := c.trimming()
+
+	if ch, ok := c.(*chat); ok {
+		// capping the capacity forces append to copy
+		history = ch.history[:len(ch.history):len(ch.history)]
+	} else {
+		h := c.History()
+		history = make([]Message, h.Len())
+		h.CopyTo(history)
+	}
+
+	return &chat{history: history, storeImpl: store.Copy(c.store()), meta: c.metadata().copy(), unbounded: unbounded, trimStrategy: trimStrategy, ctx: c.context(), sequentialParallel: c.sequential()}
+}
+
+func (t *tree) Branch() Tree {
+	child := &tree{chat: branchChat(t.chat), parent: t}
+
+	t.mu.Lock()
+	t.children = append(t.children, child)
+	t.mu.Unlock()
+
+	return child
+}
+
+func (t *tree) Children() []Tree {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return slices.Clone(t.children)
+}
+
+func (t *tree) Parent() Tree {
+	if t.parent == nil {
+		return nil
+	}
+	return t.parent
+}